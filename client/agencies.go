@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// agency Agency served by the process
+type agency struct {
+	id string
+	// shared Several agencies are served by the process, so the paths
+	// given in the configuration are shared by all of them
+	shared bool
+}
+
+// path Returns the file of the agency for a path parameter. If the
+// parameter is not set, format is filled with the agency ID, unless it
+// is empty. A configured path gets the agency ID as suffix when several
+// agencies are served, so they do not overwrite each other's files
+func (a agency) path(configured string, format string) string {
+	switch {
+	case configured == "" && format == "":
+		return ""
+	case configured == "":
+		return fmt.Sprintf(format, a.id)
+	case a.shared:
+		return common.SuffixPath(configured, a.id)
+	default:
+		return configured
+	}
+}
+
+// parseAgencyIDs Returns the agencies given by the ids parameter, a comma
+// separated list of IDs. If it is not set the single agency given by the
+// id parameter is served
+func parseAgencyIDs(v *viper.Viper) ([]string, error) {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, id := range strings.Split(v.GetString("ids"), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if seen[id] {
+			return nil, errors.Errorf("agency %q is listed more than once", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		ids = append(ids, v.GetString("id"))
+	}
+	return ids, nil
+}
+
+// agencyGroup Clients running for the agencies of the process. Once the
+// group is shut down every running client is interrupted and no new
+// client is started, so each agency finishes writing its summary
+type agencyGroup struct {
+	mu       sync.Mutex
+	clients  map[*common.Client]bool
+	stopping bool
+}
+
+// start Adds a client to the group. Returns false if the group is shut
+// down, in which case the client must not be run
+func (g *agencyGroup) start(client *common.Client) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopping {
+		return false
+	}
+	g.clients[client] = true
+	return true
+}
+
+// done Removes a client that finished its run from the group
+func (g *agencyGroup) done(client *common.Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.clients, client)
+}
+
+// shutdown Interrupts every running client of the group
+func (g *agencyGroup) shutdown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopping = true
+	for client := range g.clients {
+		client.Shutdown()
+	}
+}
+
+// stopped Returns true once the group has been shut down
+func (g *agencyGroup) stopped() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stopping
+}
+
+// runAgencies Runs fn for every agency of the process, each one in its
+// own goroutine, and returns the highest exit code. SIGTERM and SIGINT
+// shut down the clients of every agency
+func runAgencies(v *viper.Viper, fn func(group *agencyGroup, a agency) int) int {
+	// The parameter is checked when the config is loaded
+	ids, _ := parseAgencyIDs(v)
+	group := &agencyGroup{clients: map[*common.Client]bool{}}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Infof("action: shutdown | result: in_progress | signal: %v | agencies: %v", sig, len(ids))
+			group.shutdown()
+		case <-finished:
+		}
+	}()
+
+	codes := make([]int, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			codes[i] = fn(group, agency{id: id, shared: len(ids) > 1})
+		}(i, id)
+	}
+	wg.Wait()
+	close(finished)
+
+	code := 0
+	for _, c := range codes {
+		code = maxCode(code, c)
+	}
+	if group.stopped() {
+		log.Infof("action: shutdown | result: success | agencies: %v", len(ids))
+	}
+	return code
+}
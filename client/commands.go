@@ -0,0 +1,442 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+// defaultCommand Subcommand executed when none is given, so containers
+// started without arguments keep uploading their agency file
+const defaultCommand = "send"
+
+// command A client subcommand. run receives the parsed configuration and
+// the arguments that follow the subcommand name and returns the exit code
+type command struct {
+	description string
+	run         func(v *viper.Viper, args []string) int
+}
+
+var commands = map[string]command{
+	"send":        {"upload an agency file and query its winners", runSend},
+	"winners":     {"query the winners of the agency", runWinners},
+	"probe":       {"check that the server answers a single round trip", runProbe},
+	"validate":    {"check an agency file offline", runValidate},
+	"bench":       {"run a load test against the server", runBench},
+	"replay":      {"send a capture to the server and diff its responses", runReplay},
+	"conformance": {"check that a server implements the lottery protocol", runConformance},
+}
+
+// usage Prints the available subcommands to stderr
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: client [command] [flags]\n\ncommands:\n")
+	for _, name := range []string{"send", "winners", "probe", "validate", "bench", "replay", "conformance"} {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].description)
+	}
+}
+
+// newClientConfig Builds the client configuration from the parsed
+// configuration parameters
+func newClientConfig(v *viper.Viper) common.ClientConfig {
+	return common.ClientConfig{
+		ServerAddresses: common.ParseAddresses(v.GetString("server.address")),
+		ServerPolicy:    v.GetString("server.policy"),
+		ServerEjectFor:  v.GetDuration("server.ejectFor"),
+		ResponseTimeout: v.GetDuration("server.timeout"),
+		Heartbeat: common.HeartbeatConfig{
+			Interval:  v.GetDuration("heartbeat.interval"),
+			Misses:    v.GetInt("heartbeat.misses"),
+			KeepAlive: v.GetDuration("heartbeat.keepAlive"),
+		},
+		ID:             v.GetString("id"),
+		Contest:        v.GetString("contest.id"),
+		LoopAmount:     v.GetInt("loop.amount"),
+		LoopPeriod:     v.GetDuration("loop.period"),
+		BatchMaxAmount: v.GetInt("batch.maxAmount"),
+		BetsCharset:    v.GetString("bets.charset"),
+		BatchWindow:    v.GetInt("batch.window"),
+		BatchRetries:   v.GetInt("batch.retries"),
+		WinnersJSON:    v.GetString("winners.json"),
+		WinnersWait:    v.GetDuration("winners.wait"),
+		RequireDigest:  v.GetBool("digest.required"),
+		VerifyWinners:  v.GetBool("verification.enabled"),
+		WinningNumber:  v.GetInt("verification.number"),
+		CaptureFile:    v.GetString("capture.file"),
+		RateLimit: common.RateLimitConfig{
+			Bets:         v.GetFloat64("rate.bets"),
+			BetsBurst:    v.GetInt("rate.betsBurst"),
+			Batches:      v.GetFloat64("rate.batches"),
+			BatchesBurst: v.GetInt("rate.batchesBurst"),
+			Bytes:        v.GetFloat64("rate.bytes"),
+			BytesBurst:   v.GetInt("rate.bytesBurst"),
+		},
+		Faults: common.FaultConfig{
+			ShortWrites: v.GetBool("debug.faults.shortWrites"),
+			ShortReads:  v.GetBool("debug.faults.shortReads"),
+			Latency:     v.GetDuration("debug.faults.latency"),
+			DropAfter:   v.GetInt64("debug.faults.dropAfter"),
+			CorruptRate: v.GetFloat64("debug.faults.corruptRate"),
+			StallAfter:  v.GetInt64("debug.faults.stallAfter"),
+			StallFor:    v.GetDuration("debug.faults.stallFor"),
+			Seed:        v.GetInt64("debug.faults.seed"),
+		},
+		Duplicates: common.DuplicateConfig{
+			Policy:       v.GetString("duplicates.policy"),
+			ExactMaxSize: v.GetInt64("duplicates.exactMaxSize"),
+			FilterBits:   v.GetUint64("duplicates.filterBits"),
+		},
+		Validation: common.ValidationConfig{
+			DocumentEnabled:   v.GetBool("validation.document.enabled"),
+			DocumentMinDigits: v.GetInt("validation.document.minDigits"),
+			DocumentMaxDigits: v.GetInt("validation.document.maxDigits"),
+			BirthdateEnabled:  v.GetBool("validation.birthdate.enabled"),
+			BirthdateMinYear:  v.GetInt("validation.birthdate.minYear"),
+			AgeEnabled:        v.GetBool("validation.age.enabled"),
+			MinAge:            v.GetInt("validation.age.min"),
+			NumberEnabled:     v.GetBool("validation.number.enabled"),
+			NumberMin:         v.GetInt("validation.number.min"),
+			NumberMax:         v.GetInt("validation.number.max"),
+			NamesEnabled:      v.GetBool("validation.names.enabled"),
+		},
+	}
+}
+
+// betsFilePath Returns the agency file to be used. The -file flag takes
+// precedence over the bets.file parameter. If none of them is set the
+// file of the agency is looked up in the working directory
+func betsFilePath(v *viper.Viper, a agency, flagValue string) string {
+	if flagValue != "" {
+		return a.path(flagValue, "")
+	}
+	return a.path(v.GetString("bets.file"), "./agency-%s.csv")
+}
+
+// contestFiles Returns the agency file of every contest of the run. If
+// the -file flag is given or the contest.files parameter is not set, a
+// single contest given by the contest.id parameter is run
+func contestFiles(v *viper.Viper, a agency, flagValue string) []common.ContestFile {
+	if flagValue == "" {
+		// The parameter is checked when the config is loaded
+		files, _ := common.ParseContestFiles(v.GetString("contest.files"))
+		if len(files) > 0 {
+			for i := range files {
+				files[i].Path = a.path(files[i].Path, "")
+			}
+			return files
+		}
+	}
+	return []common.ContestFile{{Contest: v.GetString("contest.id"), Path: betsFilePath(v, a, flagValue)}}
+}
+
+// agencyClientConfig Returns the config of the client that runs a contest
+// of an agency. Files not set in the configuration are named after the
+// agency, and when several contests are run the contest is added to the
+// name of every output file so they do not overwrite each other
+func agencyClientConfig(v *viper.Viper, a agency, contest string, severalContests bool) common.ClientConfig {
+	config := newClientConfig(v)
+	config.ID = a.id
+	config.Contest = contest
+	config.DeadLetterFile = a.path(v.GetString("deadLetter.file"), "./rejected-agency-%s.csv")
+	config.WinnersCSV = a.path(v.GetString("winners.csv"), "./winners-agency-%s.csv")
+	config.WinnersJSON = a.path(v.GetString("winners.json"), "")
+	config.SummaryFile = a.path(v.GetString("summary.file"), "./summary-agency-%s.json")
+	config.CaptureFile = a.path(v.GetString("capture.file"), "")
+	if severalContests {
+		config.DeadLetterFile = common.SuffixPath(config.DeadLetterFile, contest)
+		config.WinnersCSV = common.SuffixPath(config.WinnersCSV, contest)
+		config.WinnersJSON = common.SuffixPath(config.WinnersJSON, contest)
+		config.SummaryFile = common.SuffixPath(config.SummaryFile, contest)
+		config.CaptureFile = common.SuffixPath(config.CaptureFile, contest)
+	}
+	return config
+}
+
+// finishRun Writes the summary of the run, releases the client and returns
+// the exit code. A run interrupted by a shutdown is not a failure
+func finishRun(client *common.Client, err error) int {
+	client.WriteSummary(err)
+	client.Close()
+	if err != nil && !client.Stopped() {
+		return 1
+	}
+	return 0
+}
+
+func runSend(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("send", flag.ExitOnError)
+	file := flags.String("file", "", "agency file to upload")
+	dryRun := flags.Bool("dry-run", false, "parse and batch the file without connecting to the server")
+	frames := flags.Int("frames", 3, "amount of encoded frames to dump in dry run mode")
+	flags.Parse(args)
+
+	return runAgencies(v, func(group *agencyGroup, a agency) int {
+		files := contestFiles(v, a, *file)
+		code := 0
+		for _, contestFile := range files {
+			client := common.NewClient(agencyClientConfig(v, a, contestFile.Contest, len(files) > 1))
+			if *dryRun {
+				code = maxCode(code, runDryRun(client, a, contestFile, *frames))
+				continue
+			}
+			if !group.start(client) {
+				break
+			}
+
+			err := client.SendBets(contestFile.Path)
+			if err == nil {
+				err = client.QueryAndExportWinners(contestFile.Path)
+			} else if !client.Stopped() {
+				log.Criticalf("action: send | result: fail | client_id: %v | contest: %v | error: %v", a.id, contestFile.Contest, err)
+			}
+			group.done(client)
+			code = maxCode(code, finishRun(client, err))
+		}
+		return code
+	})
+}
+
+// runDryRun Parses and batches the file of a contest without connecting
+// to the server
+func runDryRun(client *common.Client, a agency, contestFile common.ContestFile, frames int) int {
+	report, err := client.DryRun(contestFile.Path, frames)
+	if err != nil {
+		log.Criticalf("action: dry_run | result: fail | client_id: %v | contest: %v | error: %v", a.id, contestFile.Contest, err)
+		return 1
+	}
+	log.Infof("action: dry_run | result: success | client_id: %v | contest: %v | batches: %v | bets: %v | rejected: %v",
+		a.id,
+		contestFile.Contest,
+		report.Batches,
+		report.Bets,
+		len(report.Rejected),
+	)
+	report.Write(os.Stdout)
+	return 0
+}
+
+// maxCode Returns the exit code of a run made of several steps, which
+// fails if any of them failed
+func maxCode(a int, b int) int {
+	if b > a {
+		return b
+	}
+	return a
+}
+
+func runWinners(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("winners", flag.ExitOnError)
+	file := flags.String("file", "", "agency file used to complete the winners data")
+	flags.Parse(args)
+
+	return runAgencies(v, func(group *agencyGroup, a agency) int {
+		files := contestFiles(v, a, *file)
+		code := 0
+		for _, contestFile := range files {
+			client := common.NewClient(agencyClientConfig(v, a, contestFile.Contest, len(files) > 1))
+			if !group.start(client) {
+				break
+			}
+			err := client.QueryAndExportWinners(contestFile.Path)
+			group.done(client)
+			code = maxCode(code, finishRun(client, err))
+		}
+		return code
+	})
+}
+
+func runProbe(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	flags.Parse(args)
+
+	client := common.NewClient(newClientConfig(v))
+	if err := client.Probe(); err != nil {
+		log.Debugf("action: test_echo_server | result: fail | error: %v", err)
+		fmt.Println("action: test_echo_server | result: fail")
+		return 1
+	}
+	fmt.Println("action: test_echo_server | result: success")
+	return 0
+}
+
+func runValidate(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := flags.String("file", "", "agency file to check")
+	flags.Parse(args)
+
+	path := betsFilePath(v, agency{id: v.GetString("id")}, *file)
+	client := common.NewClient(newClientConfig(v))
+	report, err := client.ValidateBets(path)
+	if err != nil {
+		log.Criticalf("action: validate | result: fail | file: %v | error: %v", path, err)
+		return 1
+	}
+
+	result := "success"
+	if report.Invalid > 0 {
+		result = "fail"
+	}
+	log.Infof("action: validate | result: %v | file: %v | rows: %v | valid: %v | invalid: %v",
+		result,
+		path,
+		report.Rows,
+		report.Valid,
+		report.Invalid,
+	)
+	if report.Invalid > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runBench(v *viper.Viper, args []string) int {
+	clientConfig := newClientConfig(v)
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	agencies := flags.Int("agencies", 5, "amount of virtual agencies")
+	firstID := flags.Int("first-id", 1, "ID of the first virtual agency")
+	bets := flags.Int("bets", 1000, "synthetic bets per agency, used when -data is not given")
+	data := flags.String("data", "", "comma separated agency files to replay")
+	batchSize := flags.Int("batch", clientConfig.BatchMaxAmount, "maximum amount of bets per batch")
+	window := flags.Int("window", clientConfig.BatchWindow, "maximum amount of batches in flight per agency")
+	think := flags.Duration("think", 0, "time to wait between batches")
+	jsonPath := flags.String("json", "", "write the report as JSON to this file, - for stdout")
+	// Like send, agencies fail against a server without digests unless
+	// disabled here or in digest.required
+	requireDigest := flags.Bool("require-digest", clientConfig.RequireDigest, "fail agencies whose server answers BATCH_END without digest")
+	flags.Parse(args)
+
+	benchConfig := common.BenchConfig{
+		Agencies:      *agencies,
+		FirstAgencyID: *firstID,
+		BetsPerAgency: *bets,
+		ThinkTime:     *think,
+	}
+	if *data != "" {
+		benchConfig.Files = strings.Split(*data, ",")
+	}
+	clientConfig.BatchMaxAmount = *batchSize
+	clientConfig.BatchWindow = *window
+	clientConfig.RequireDigest = *requireDigest
+	// Virtual agencies would overwrite each other's capture
+	clientConfig.CaptureFile = ""
+
+	report := common.RunBench(clientConfig, benchConfig)
+	log.Infof("action: bench | result: success | agencies: %v | batches: %v | errors: %v | duration: %.3fs",
+		report.Agencies,
+		report.Batches,
+		report.Errors,
+		report.DurationSeconds,
+	)
+	report.WriteTable(os.Stdout)
+
+	switch *jsonPath {
+	case "":
+	case "-":
+		report.WriteJSON(os.Stdout)
+	default:
+		file, err := os.Create(*jsonPath)
+		if err != nil {
+			log.Errorf("action: bench_report | result: fail | file: %v | error: %v", *jsonPath, err)
+			return 1
+		}
+		err = report.WriteJSON(file)
+		// A failed close may lose the buffered report
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Errorf("action: bench_report | result: fail | file: %v | error: %v", *jsonPath, err)
+			return 1
+		}
+	}
+
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runReplay(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	capturePath := flags.String("capture", v.GetString("capture.file"), "capture file to replay")
+	server := flags.String("server", "", "server to replay against, defaults to the first configured server")
+	flags.Parse(args)
+
+	address := *server
+	if address == "" {
+		if addresses := common.ParseAddresses(v.GetString("server.address")); len(addresses) > 0 {
+			address = addresses[0]
+		}
+	}
+	if *capturePath == "" || address == "" {
+		log.Criticalf("action: replay | result: fail | error: a capture file and a server address are required")
+		return 2
+	}
+
+	records, err := common.ReadCapture(*capturePath)
+	if err != nil {
+		log.Criticalf("action: replay | result: fail | capture: %v | error: %v", *capturePath, err)
+		return 1
+	}
+	report, err := common.Replay(address, records)
+	report.Write(os.Stdout)
+	if err != nil {
+		log.Criticalf("action: replay | result: fail | capture: %v | server: %v | error: %v", *capturePath, address, err)
+		return 1
+	}
+
+	result := "success"
+	if len(report.Mismatches) > 0 {
+		result = "fail"
+	}
+	log.Infof("action: replay | result: %v | capture: %v | server: %v | frames: %v | mismatches: %v",
+		result,
+		*capturePath,
+		address,
+		report.Sent+report.Received,
+		len(report.Mismatches),
+	)
+	if len(report.Mismatches) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runConformance(v *viper.Viper, args []string) int {
+	flags := flag.NewFlagSet("conformance", flag.ExitOnError)
+	server := flags.String("server", "", "server to check, defaults to the first configured server")
+	firstAgency := flags.Int("first-agency", 1, "ID of the first agency used by the suite")
+	agencies := flags.Int("agencies", 5, "amount of agencies expected by the server")
+	timeout := flags.Duration("timeout", 5*time.Second, "maximum time to wait for each response")
+	wait := flags.Duration("wait", 10*time.Second, "maximum time to wait for the draw")
+	flags.Parse(args)
+
+	address := *server
+	if address == "" {
+		if addresses := common.ParseAddresses(v.GetString("server.address")); len(addresses) > 0 {
+			address = addresses[0]
+		}
+	}
+
+	results := common.RunConformance(common.ConformanceConfig{
+		Address:     address,
+		FirstAgency: *firstAgency,
+		Agencies:    *agencies,
+		Timeout:     *timeout,
+		WinnersWait: *wait,
+	})
+	common.WriteConformanceReport(os.Stdout, results)
+
+	for _, result := range results {
+		if !result.Passed {
+			return 1
+		}
+	}
+	return 0
+}
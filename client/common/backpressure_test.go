@@ -0,0 +1,122 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBusyBatchIsSentAgain(t *testing.T) {
+	server := newTestServer(t)
+	server.busy = []string{"50"}
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+	})
+
+	start := time.Now()
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 30, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("upload took %v, the server asked to wait 50ms", elapsed)
+	}
+	if stored, finished := server.stored("1"); stored != 30 || !finished {
+		t.Fatalf("server stored %d of 30 bets (finished: %v)", stored, finished)
+	}
+	if client.backpressure != 50*time.Millisecond {
+		t.Fatalf("paused %v, want 50ms", client.backpressure)
+	}
+}
+
+func TestBusyDiscardsWindow(t *testing.T) {
+	server := newTestServer(t)
+	// The first batch is refused while the rest of the window is in flight
+	server.busy = []string{"10"}
+	retries := 0
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		BatchWindow:     4,
+	})
+	client.onRetry = func() { retries++ }
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 60, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	// The rest of the window is discarded and sent again after batch 1
+	if want := []int{2, 3, 4, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(server.received, want) {
+		t.Fatalf("server received batches %v, want %v", server.received, want)
+	}
+	if stored, finished := server.stored("1"); stored != 60 || !finished || retries != 0 {
+		t.Fatalf("server stored %d of 60 bets (finished: %v) with %d retries", stored, finished, retries)
+	}
+}
+
+func TestBusyBatchEndIsSentAgain(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{ID: "1", ServerAddresses: []string{server.address()}})
+	if err := client.createClientSocket(); err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer client.closeClientSocket()
+	server.busy = []string{"10", "10"}
+
+	response, err := client.exchange(NewBatchEndMessage("1", DefaultContest, Digest{}))
+	if err != nil || response.Type != MsgAck {
+		t.Fatalf("got %v (%v), want ACK", response, err)
+	}
+	if client.backpressure != 20*time.Millisecond {
+		t.Fatalf("paused %v, want 20ms", client.backpressure)
+	}
+}
+
+func TestMalformedBusyHintUsesLoopPeriod(t *testing.T) {
+	server := newTestServer(t)
+	server.busy = []string{"soon"}
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      30 * time.Millisecond,
+		BatchMaxAmount:  10,
+	})
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 10, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if client.backpressure != 30*time.Millisecond {
+		t.Fatalf("paused %v, want the loop period", client.backpressure)
+	}
+}
+
+func TestBusyHintIsCapped(t *testing.T) {
+	for _, hint := range []string{"86400000", "9223372036854775807"} {
+		client := NewClient(ClientConfig{ID: "1", LoopPeriod: 30 * time.Millisecond})
+		// A shut down client does not wait, but accounts for the pause
+		client.Shutdown()
+		client.waitBackpressure(&Message{Type: MsgBusy, Body: []byte(hint)})
+		if client.backpressure != maxRetryAfter {
+			t.Fatalf("hint %q: paused %v, want %v", hint, client.backpressure, maxRetryAfter)
+		}
+	}
+}
+
+func TestDecodeRetryAfter(t *testing.T) {
+	cases := map[string]time.Duration{
+		"250":   250 * time.Millisecond,
+		"0":     0,
+		" 15\n": 15 * time.Millisecond,
+	}
+	for hint, want := range cases {
+		if got, ok := DecodeRetryAfter([]byte(hint)); !ok || got != want {
+			t.Fatalf("hint %q: got %v (ok: %v), want %v", hint, got, ok, want)
+		}
+	}
+	for _, hint := range []string{"soon", "-5", "", "1.5"} {
+		if _, ok := DecodeRetryAfter([]byte(hint)); ok {
+			t.Fatalf("hint %q must be invalid", hint)
+		}
+	}
+}
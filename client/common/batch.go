@@ -0,0 +1,225 @@
+package common
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// BatchSource Provides the batches of bets to be uploaded. NextBatch
+// returns io.EOF once there are no more bets
+type BatchSource interface {
+	NextBatch() ([]*Bet, error)
+}
+
+// Rejection A row of the agency file that could not be turned into a bet
+type Rejection struct {
+	Line   int
+	Record []string
+	Reason string
+}
+
+// RowError An invalid row of the agency file
+type RowError struct {
+	Line   int
+	Record []string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// BatchReader Reads bets from an agency file and groups them in batches
+// that respect both the configured amount of bets and the maximum
+// payload size of the protocol. Invalid rows are skipped and kept as
+// rejections
+type BatchReader struct {
+	agency    string
+	maxAmount int
+	file      *os.File
+	reader    *csv.Reader
+	line      int
+	pending   *Bet
+	rejected  []Rejection
+	validator *Validator
+	// duplicates Detects the bets repeated in the file, if set
+	duplicates *duplicateDetector
+}
+
+// NewBatchReader Opens the agency file located at path, encoded with the
+// given charset. The caller is responsible for calling Close once the
+// reader is no longer needed
+func NewBatchReader(agency string, path string, charset string, maxAmount int) (*BatchReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open bets file %s", path)
+	}
+	decoded, err := newCharsetReader(file, charset)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if maxAmount <= 0 {
+		maxAmount = 1
+	}
+
+	reader := csv.NewReader(decoded)
+	reader.FieldsPerRecord = -1
+	return &BatchReader{
+		agency:    agency,
+		maxAmount: maxAmount,
+		file:      file,
+		reader:    reader,
+	}, nil
+}
+
+// SetValidator Makes the reader check every bet against the rules of
+// validator. Bets that break a rule are treated as invalid rows
+func (r *BatchReader) SetValidator(validator *Validator) {
+	r.validator = validator
+}
+
+// setDuplicateDetector Makes the reader apply the policy of detector to
+// the bets repeated in the file
+func (r *BatchReader) setDuplicateDetector(detector *duplicateDetector) {
+	r.duplicates = detector
+}
+
+// Duplicates Returns the amount of repeated bets found so far
+func (r *BatchReader) Duplicates() int {
+	if r.duplicates == nil {
+		return 0
+	}
+	return r.duplicates.found
+}
+
+// Line Returns the number of the last line read from the file
+func (r *BatchReader) Line() int {
+	return r.line
+}
+
+// Rejected Returns the rows skipped so far
+func (r *BatchReader) Rejected() []Rejection {
+	return r.rejected
+}
+
+// ReadBet Reads the next bet of the file. io.EOF is returned when there
+// are no more bets. Invalid rows are reported as a *RowError, any other
+// error means the file cannot be read anymore
+func (r *BatchReader) ReadBet() (*Bet, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	r.line++
+	if err != nil {
+		if _, ok := err.(*csv.ParseError); !ok {
+			return nil, err
+		}
+		return nil, &RowError{Line: r.line, Record: record, Err: err}
+	}
+
+	bet, err := NewBetFromRecord(r.agency, record)
+	if err != nil {
+		return nil, &RowError{Line: r.line, Record: record, Err: err}
+	}
+	bet.Line = r.line
+	if r.validator != nil {
+		if err := r.validator.Validate(bet); err != nil {
+			return nil, &RowError{Line: r.line, Record: record, Err: err}
+		}
+	}
+	return bet, nil
+}
+
+// nextValidBet Reads bets until a valid one is found. Invalid rows are
+// logged and recorded as rejections. Repeated bets are handled according
+// to the duplicates policy
+func (r *BatchReader) nextValidBet() (*Bet, error) {
+	for {
+		bet, err := r.ReadBet()
+		if rowErr, ok := err.(*RowError); ok {
+			log.Warningf("action: leer_apuesta | result: fail | client_id: %v | error: %v", r.agency, rowErr)
+			r.rejected = append(r.rejected, Rejection{Line: rowErr.Line, Record: rowErr.Record, Reason: rowErr.Err.Error()})
+			continue
+		}
+		if err != nil || r.duplicates == nil {
+			return bet, err
+		}
+
+		dupErr := r.duplicates.check(bet)
+		if dupErr == nil {
+			return bet, nil
+		}
+		log.Warningf("action: apuesta_duplicada | result: success | client_id: %v | line: %v | first_line: %v | dni: %v | numero: %v | policy: %v",
+			r.agency,
+			dupErr.Line,
+			dupErr.FirstLine,
+			dupErr.Document,
+			dupErr.Number,
+			r.duplicates.policy,
+		)
+		switch r.duplicates.policy {
+		case DuplicateDrop:
+			r.rejected = append(r.rejected, Rejection{Line: bet.Line, Record: bet.Record(), Reason: dupErr.Error()})
+		case DuplicateFail:
+			return nil, errors.Wrapf(dupErr, "line %d", bet.Line)
+		default:
+			return bet, nil
+		}
+	}
+}
+
+// NextBatch Returns the next batch of bets. io.EOF is returned once the
+// whole file has been consumed
+func (r *BatchReader) NextBatch() ([]*Bet, error) {
+	batch := []*Bet{}
+	// The type, the batch header and their separators are part of the
+	// payload too
+	size := len(MsgBetBatch) + 1 + maxBatchHeaderSize
+
+	for len(batch) < r.maxAmount {
+		bet := r.pending
+		r.pending = nil
+		if bet == nil {
+			var err error
+			bet, err = r.nextValidBet()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		betSize := len(bet.Encode())
+		if len(batch) > 0 {
+			betSize += len(betSeparator)
+		}
+		if size+betSize > MaxPayloadSize {
+			if len(batch) == 0 {
+				return nil, errors.Errorf("line %d: bet does not fit in a single message", r.line)
+			}
+			// Keep the bet for the next batch
+			r.pending = bet
+			break
+		}
+
+		batch = append(batch, bet)
+		size += betSize
+	}
+
+	if len(batch) == 0 {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+// Close Closes the underlying agency file
+func (r *BatchReader) Close() error {
+	return r.file.Close()
+}
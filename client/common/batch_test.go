@@ -0,0 +1,111 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeAgencyFile Writes an agency file with a bet for each document and
+// number pair and returns its path
+func writeAgencyFile(t *testing.T, pairs [][2]int) string {
+	var rows strings.Builder
+	for _, pair := range pairs {
+		fmt.Fprintf(&rows, "Nombre,Apellido,%d,1990-01-01,%d\n", pair[0], pair[1])
+	}
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(rows.String()), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	return path
+}
+
+// sequentialBets Returns amount document and number pairs with
+// consecutive documents starting at 30000001
+func sequentialBets(amount int) [][2]int {
+	pairs := make([][2]int, amount)
+	for i := range pairs {
+		pairs[i] = [2]int{30000001 + i, i}
+	}
+	return pairs
+}
+
+func TestBatchesRespectMaxAmount(t *testing.T) {
+	reader, err := NewBatchReader("1", writeAgencyFile(t, sequentialBets(25)), CharsetUTF8, 10)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	defer reader.Close()
+
+	sizes := []int{}
+	for {
+		batch, err := reader.NextBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read batch: %v", err)
+		}
+		sizes = append(sizes, len(batch))
+	}
+	if want := "[10 10 5]"; fmt.Sprint(sizes) != want {
+		t.Fatalf("got batches of %v bets, want %v", sizes, want)
+	}
+}
+
+func TestBatchesFitInPayload(t *testing.T) {
+	name := strings.Repeat("n", 2000)
+	var rows strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&rows, "%s,Lorca,%d,1999-03-17,%d\n", name, 30904465+i, i)
+	}
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(rows.String()), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	reader, err := NewBatchReader("1", path, CharsetUTF8, 10)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	defer reader.Close()
+
+	bets, batches := 0, 0
+	for {
+		batch, err := reader.NextBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read batch: %v", err)
+		}
+		msg := &Message{Type: MsgBetBatch, Body: EncodeBatch(batch)}
+		if _, err := msg.Encode(); err != nil {
+			t.Fatalf("batch of %d bets does not fit: %v", len(batch), err)
+		}
+		bets += len(batch)
+		batches++
+	}
+	if bets != 10 || batches < 3 {
+		t.Fatalf("read %d bets in %d batches, want 10 bets in several batches", bets, batches)
+	}
+}
+
+func TestBetTooLargeForPayloadFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	row := strings.Repeat("n", MaxPayloadSize) + ",Lorca,30904465,1999-03-17,1\n"
+	if err := os.WriteFile(path, []byte(row), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	reader, err := NewBatchReader("1", path, CharsetUTF8, 10)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.NextBatch(); err == nil || err == io.EOF {
+		t.Fatalf("got %v, want an error for a bet larger than a message", err)
+	}
+}
@@ -0,0 +1,235 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+var syntheticNames = []string{"Santiago", "Camila", "Tiago", "Agustin", "Valentina", "Diego", "Sofia", "Lucia"}
+var syntheticSurnames = []string{"Lorca", "Zambrano", "Rivera", "Varela", "Mamani", "Gomez", "Fernandez", "Diaz"}
+
+// BenchConfig Parameters of a load test. Every virtual agency replays
+// one of Files (assigned round robin) or, when no files are given,
+// BetsPerAgency synthetic bets
+type BenchConfig struct {
+	Agencies      int
+	FirstAgencyID int
+	BetsPerAgency int
+	Files         []string
+	ThinkTime     time.Duration
+}
+
+// BenchReport Results of a load test. Latencies are expressed in
+// milliseconds
+type BenchReport struct {
+	Agencies         int     `json:"agencies"`
+	Batches          int     `json:"batches"`
+	Bets             int     `json:"bets"`
+	Errors           int     `json:"errors"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	BetsPerSecond    float64 `json:"bets_per_second"`
+	BatchesPerSecond float64 `json:"batches_per_second"`
+	LatencyP50Ms     float64 `json:"latency_p50_ms"`
+	LatencyP95Ms     float64 `json:"latency_p95_ms"`
+	LatencyP99Ms     float64 `json:"latency_p99_ms"`
+}
+
+// SyntheticBatchSource Generates random bets for an agency
+type SyntheticBatchSource struct {
+	agency    string
+	remaining int
+	maxAmount int
+	rnd       *rand.Rand
+}
+
+// NewSyntheticBatchSource Creates a source that generates amount bets in
+// batches of up to maxAmount bets. The same seed yields the same bets
+func NewSyntheticBatchSource(agency string, amount int, maxAmount int, seed int64) *SyntheticBatchSource {
+	if maxAmount <= 0 {
+		maxAmount = 1
+	}
+	return &SyntheticBatchSource{
+		agency:    agency,
+		remaining: amount,
+		maxAmount: maxAmount,
+		rnd:       rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NextBatch Returns the next batch of synthetic bets
+func (s *SyntheticBatchSource) NextBatch() ([]*Bet, error) {
+	if s.remaining <= 0 {
+		return nil, io.EOF
+	}
+
+	size := s.maxAmount
+	if size > s.remaining {
+		size = s.remaining
+	}
+	s.remaining -= size
+
+	batch := make([]*Bet, 0, size)
+	for i := 0; i < size; i++ {
+		batch = append(batch, &Bet{
+			Agency:    s.agency,
+			FirstName: syntheticNames[s.rnd.Intn(len(syntheticNames))],
+			LastName:  syntheticSurnames[s.rnd.Intn(len(syntheticSurnames))],
+			Document:  strconv.Itoa(20000000 + s.rnd.Intn(25000000)),
+			Birthdate: fmt.Sprintf("%d-%02d-%02d", 1950+s.rnd.Intn(55), 1+s.rnd.Intn(12), 1+s.rnd.Intn(28)),
+			Number:    s.rnd.Intn(10000),
+		})
+	}
+	return batch, nil
+}
+
+// benchStats Measurements shared by all the virtual agencies
+type benchStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	bets      int
+	errors    int
+}
+
+func (s *benchStats) recordBatch(bets int, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, latency)
+	s.bets += bets
+}
+
+func (s *benchStats) recordError() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+}
+
+// percentile Returns the p-th percentile of the sorted latencies using
+// the nearest rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// RunBench Runs a load test against the server. Each virtual agency is a
+// Client running on its own goroutine with its own connection. base is
+// used as the configuration of every agency, replacing only its ID
+func RunBench(base ClientConfig, config BenchConfig) *BenchReport {
+	stats := &benchStats{}
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < config.Agencies; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			runBenchAgency(base, config, index, stats)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	sort.Slice(stats.latencies, func(i, j int) bool { return stats.latencies[i] < stats.latencies[j] })
+	report := &BenchReport{
+		Agencies:        config.Agencies,
+		Batches:         len(stats.latencies),
+		Bets:            stats.bets,
+		Errors:          stats.errors,
+		DurationSeconds: elapsed.Seconds(),
+		LatencyP50Ms:    toMillis(percentile(stats.latencies, 50)),
+		LatencyP95Ms:    toMillis(percentile(stats.latencies, 95)),
+		LatencyP99Ms:    toMillis(percentile(stats.latencies, 99)),
+	}
+	if elapsed > 0 {
+		report.BetsPerSecond = float64(report.Bets) / elapsed.Seconds()
+		report.BatchesPerSecond = float64(report.Batches) / elapsed.Seconds()
+	}
+	return report
+}
+
+// thinkingSource Wraps a source waiting a fixed time before handing out
+// every batch but the first one
+type thinkingSource struct {
+	BatchSource
+	think   time.Duration
+	started bool
+}
+
+func (s *thinkingSource) NextBatch() ([]*Bet, error) {
+	if s.started {
+		time.Sleep(s.think)
+	}
+	s.started = true
+	return s.BatchSource.NextBatch()
+}
+
+// runBenchAgency Uploads the bets of a single virtual agency. Every
+// retried attempt and a failed upload count as errors
+func runBenchAgency(base ClientConfig, config BenchConfig, index int, stats *benchStats) {
+	id := strconv.Itoa(config.FirstAgencyID + index)
+	clientConfig := base
+	clientConfig.ID = id
+	client := NewClient(clientConfig)
+	client.onAck = func(bets []*Bet, latency time.Duration) {
+		stats.recordBatch(len(bets), latency)
+	}
+	client.onRetry = stats.recordError
+
+	var source BatchSource
+	if len(config.Files) > 0 {
+		reader, err := NewBatchReader(id, config.Files[index%len(config.Files)], base.BetsCharset, base.BatchMaxAmount)
+		if err != nil {
+			log.Errorf("action: bench_agency | result: fail | client_id: %v | error: %v", id, err)
+			stats.recordError()
+			return
+		}
+		defer reader.Close()
+		source = reader
+	} else {
+		source = NewSyntheticBatchSource(id, config.BetsPerAgency, base.BatchMaxAmount, int64(config.FirstAgencyID+index))
+	}
+
+	if err := client.UploadBatches(&thinkingSource{BatchSource: source, think: config.ThinkTime}); err != nil {
+		log.Errorf("action: bench_agency | result: fail | client_id: %v | error: %v", id, err)
+		stats.recordError()
+	}
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// WriteTable Writes the report as a human readable table
+func (r *BenchReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "agencies\t%d\n", r.Agencies)
+	fmt.Fprintf(tw, "batches\t%d\n", r.Batches)
+	fmt.Fprintf(tw, "bets\t%d\n", r.Bets)
+	fmt.Fprintf(tw, "errors\t%d\n", r.Errors)
+	fmt.Fprintf(tw, "duration\t%.3fs\n", r.DurationSeconds)
+	fmt.Fprintf(tw, "throughput\t%.1f bets/s\t%.1f batches/s\n", r.BetsPerSecond, r.BatchesPerSecond)
+	fmt.Fprintf(tw, "latency p50\t%.3fms\n", r.LatencyP50Ms)
+	fmt.Fprintf(tw, "latency p95\t%.3fms\n", r.LatencyP95Ms)
+	fmt.Fprintf(tw, "latency p99\t%.3fms\n", r.LatencyP99Ms)
+	return tw.Flush()
+}
+
+// WriteJSON Writes the report as indented JSON
+func (r *BenchReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
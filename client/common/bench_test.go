@@ -0,0 +1,104 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// readAll Returns every bet of source
+func readAll(t *testing.T, source BatchSource) []*Bet {
+	bets := []*Bet{}
+	for {
+		batch, err := source.NextBatch()
+		if err == io.EOF {
+			return bets
+		}
+		if err != nil {
+			t.Fatalf("could not read batch: %v", err)
+		}
+		bets = append(bets, batch...)
+	}
+}
+
+func TestSyntheticSourceIsDeterministic(t *testing.T) {
+	first := readAll(t, NewSyntheticBatchSource("3", 25, 10, 42))
+	second := readAll(t, NewSyntheticBatchSource("3", 25, 10, 42))
+	if len(first) != 25 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("the same seed yielded different bets")
+	}
+	if reflect.DeepEqual(first, readAll(t, NewSyntheticBatchSource("3", 25, 10, 43))) {
+		t.Fatalf("different seeds yielded the same bets")
+	}
+}
+
+func TestPercentileNearestRank(t *testing.T) {
+	latencies := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	cases := map[float64]time.Duration{0: time.Millisecond, 50: 50 * time.Millisecond, 95: 95 * time.Millisecond, 100: 100 * time.Millisecond}
+	for p, want := range cases {
+		if got := percentile(latencies, p); got != want {
+			t.Fatalf("p%v: got %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Fatalf("percentile of no latencies: got %v, want 0", got)
+	}
+}
+
+func TestBenchUploadsEveryAgency(t *testing.T) {
+	server := newTestServer(t)
+	base := ClientConfig{ServerAddresses: []string{server.address()}, BatchMaxAmount: 10}
+
+	report := RunBench(base, BenchConfig{Agencies: 3, FirstAgencyID: 5, BetsPerAgency: 25})
+	if report.Agencies != 3 || report.Bets != 75 || report.Batches != 9 || report.Errors != 0 {
+		t.Fatalf("unexpected report %+v", *report)
+	}
+	for agency := 5; agency <= 7; agency++ {
+		if stored, finished := server.stored(strconv.Itoa(agency)); stored != 25 || !finished {
+			t.Fatalf("agency %d: server stored %d of 25 bets (finished: %v)", agency, stored, finished)
+		}
+	}
+	if report.LatencyP50Ms > report.LatencyP99Ms || report.BetsPerSecond <= 0 {
+		t.Fatalf("inconsistent measurements %+v", *report)
+	}
+}
+
+func TestBenchReplaysFilesRoundRobin(t *testing.T) {
+	server := newTestServer(t)
+	files := []string{writeAgencyFile(t, sequentialBets(4)), writeAgencyFile(t, sequentialBets(6))}
+	base := ClientConfig{ServerAddresses: []string{server.address()}, BatchMaxAmount: 10}
+
+	report := RunBench(base, BenchConfig{Agencies: 3, FirstAgencyID: 1, Files: files})
+	if report.Bets != 14 || report.Errors != 0 {
+		t.Fatalf("unexpected report %+v", *report)
+	}
+	for agency, want := range map[string]int{"1": 4, "2": 6, "3": 4} {
+		if stored, _ := server.stored(agency); stored != want {
+			t.Fatalf("agency %s: server stored %d bets, want %d", agency, stored, want)
+		}
+	}
+}
+
+func TestBenchCountsFailedAgencies(t *testing.T) {
+	base := ClientConfig{ServerAddresses: []string{"127.0.0.1:1"}, BatchMaxAmount: 10}
+
+	report := RunBench(base, BenchConfig{Agencies: 2, FirstAgencyID: 1, BetsPerAgency: 5})
+	if report.Bets != 0 || report.Errors < 2 {
+		t.Fatalf("unexpected report %+v", *report)
+	}
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatalf("could not write report: %v", err)
+	}
+	var decoded BenchReport
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.Errors != report.Errors {
+		t.Fatalf("could not read back report %s: %v", out.String(), err)
+	}
+}
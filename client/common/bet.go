@@ -0,0 +1,113 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// betFields Amount of columns expected in every row of an agency file
+const betFields = 5
+
+// betKeys Keys of the encoded bet fields, in order
+var betKeys = []string{"agency", "dni", "number", "first_name", "last_name", "birthdate"}
+
+// Bet A lottery bet registered by an agency
+type Bet struct {
+	Agency    string
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    int
+	// Line Line of the agency file the bet was read from, zero if the
+	// bet does not come from a file
+	Line int
+}
+
+// NewBetFromRecord Builds a bet from a row of the agency file. Rows have
+// the format first_name,last_name,document,birthdate,number. If the row
+// does not have the expected amount of fields, is not valid UTF-8 or the
+// number cannot be parsed, an error is returned. Names are normalized to
+// NFC so the same name always has the same bytes
+func NewBetFromRecord(agency string, record []string) (*Bet, error) {
+	if len(record) != betFields {
+		return nil, errors.Errorf("expected %d fields, got %d", betFields, len(record))
+	}
+	for _, field := range record {
+		if !utf8.ValidString(field) {
+			return nil, errors.Errorf("invalid UTF-8 in field %q", field)
+		}
+	}
+
+	number, err := strconv.Atoi(strings.TrimSpace(record[4]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid number %q", record[4])
+	}
+
+	return &Bet{
+		Agency:    agency,
+		FirstName: norm.NFC.String(record[0]),
+		LastName:  norm.NFC.String(record[1]),
+		Document:  strings.TrimSpace(record[2]),
+		Birthdate: strings.TrimSpace(record[3]),
+		Number:    number,
+	}, nil
+}
+
+// Record Returns the bet as a row with the agency file format
+func (b *Bet) Record() []string {
+	return []string{b.FirstName, b.LastName, b.Document, b.Birthdate, strconv.Itoa(b.Number)}
+}
+
+// Encode Serializes the bet with the text format expected by the server.
+// Values are escaped so they can hold any of the delimiters of the format
+func (b *Bet) Encode() string {
+	return fmt.Sprintf(
+		"agency:%s|dni:%s|number:%d|first_name:%s|last_name:%s|birthdate:%s",
+		escapeValue(b.Agency),
+		escapeValue(b.Document),
+		b.Number,
+		escapeValue(b.FirstName),
+		escapeValue(b.LastName),
+		escapeValue(b.Birthdate),
+	)
+}
+
+// DecodeBet Parses a bet serialized with Encode
+func DecodeBet(encoded string) (*Bet, error) {
+	fields := splitEscaped(encoded, fieldSeparator)
+	if len(fields) != len(betKeys) {
+		return nil, errors.Errorf("expected %d fields, got %d", len(betKeys), len(fields))
+	}
+
+	values := make(map[string]string, len(fields))
+	for i, field := range fields {
+		parts := splitEscaped(field, keySeparator)
+		if len(parts) != 2 || parts[0] != betKeys[i] {
+			return nil, errors.Errorf("expected field %s, got %q", betKeys[i], field)
+		}
+		value, err := unescapeValue(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "field %s", betKeys[i])
+		}
+		values[parts[0]] = value
+	}
+
+	number, err := strconv.Atoi(values["number"])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid number %q", values["number"])
+	}
+	return &Bet{
+		Agency:    values["agency"],
+		Document:  values["dni"],
+		Number:    number,
+		FirstName: values["first_name"],
+		LastName:  values["last_name"],
+		Birthdate: values["birthdate"],
+	}, nil
+}
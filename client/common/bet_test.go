@@ -0,0 +1,124 @@
+//go:build go1.18
+
+package common
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzBetRoundTrip(f *testing.F) {
+	f.Add("1", "Santiago Lionel", "Lorca", "30904465", "1999-03-17", 2201)
+	f.Add("2", "Ana|María", "O;Neil", "1:2", "line\nbreak", -1)
+	f.Add(`\`, `\n`, `\\|`, "\r\n", "", 0)
+	f.Add("", "", "", "", "", 9999)
+
+	f.Fuzz(func(t *testing.T, agency, firstName, lastName, document, birthdate string, number int) {
+		bet := &Bet{
+			Agency:    agency,
+			FirstName: firstName,
+			LastName:  lastName,
+			Document:  document,
+			Birthdate: birthdate,
+			Number:    number,
+		}
+
+		decoded, err := DecodeBet(bet.Encode())
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", bet.Encode(), err)
+		}
+		if !reflect.DeepEqual(bet, decoded) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, bet)
+		}
+	})
+}
+
+func FuzzBatchRoundTrip(f *testing.F) {
+	f.Add("Juan;Pablo", "Pérez", "a|b", "c:d")
+	f.Add("", "", "", "")
+
+	f.Fuzz(func(t *testing.T, first, second, third, fourth string) {
+		bets := []*Bet{
+			{Agency: "1", FirstName: first, LastName: second, Document: "1", Birthdate: "2000-01-01", Number: 1},
+			{Agency: third, FirstName: fourth, LastName: first, Document: second, Birthdate: third, Number: 2},
+		}
+
+		decoded, err := DecodeBatch(EncodeBatch(bets))
+		if err != nil {
+			t.Fatalf("could not decode batch: %v", err)
+		}
+		if !reflect.DeepEqual(bets, decoded) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, bets)
+		}
+	})
+}
+
+func FuzzDecodeBet(f *testing.F) {
+	f.Add("agency:1|dni:30904465|number:2201|first_name:Santiago Lionel|last_name:Lorca|birthdate:1999-03-17")
+	f.Add(`agency:2|dni:1\:2|number:-1|first_name:Ana\|María|last_name:O\;Neil|birthdate:line\nbreak`)
+	f.Add(`agency:1|dni:1|number:1|first_name:\x|last_name:|birthdate:`)
+	f.Add(`agency:1|dni:1|number:1|first_name:|last_name:|birthdate:\`)
+	f.Add("dni:1|agency:1")
+
+	f.Fuzz(func(t *testing.T, encoded string) {
+		bet, err := DecodeBet(encoded)
+		if err != nil {
+			return
+		}
+		decoded, err := DecodeBet(bet.Encode())
+		if err != nil {
+			t.Fatalf("could not decode %q, encoded from %q: %v", bet.Encode(), encoded, err)
+		}
+		if !reflect.DeepEqual(bet, decoded) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, bet)
+		}
+	})
+}
+
+func FuzzDecodeBatch(f *testing.F) {
+	f.Add([]byte("agency:1|dni:30904465|number:2201|first_name:Santiago Lionel|last_name:Lorca|birthdate:1999-03-17;" +
+		"agency:1|dni:21689196|number:9325|first_name:Agustin Emanuel|last_name:Zambrano|birthdate:2000-05-10"))
+	f.Add([]byte(`agency:1|dni:1|number:1|first_name:a\;b|last_name:|birthdate:;`))
+	f.Add([]byte(";;"))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		bets, err := DecodeBatch(body)
+		if err != nil {
+			return
+		}
+		decoded, err := DecodeBatch(EncodeBatch(bets))
+		if err != nil {
+			t.Fatalf("could not decode batch encoded from %q: %v", body, err)
+		}
+		if !reflect.DeepEqual(bets, decoded) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, bets)
+		}
+	})
+}
+
+func FuzzNewBetFromRecord(f *testing.F) {
+	f.Add("Santiago Lionel", "Lorca", "30904465", "1999-03-17", "2201")
+	f.Add("José", "Pérez", " 123 ", "", " 7 ")
+	f.Add("\xe9", "", "", "", "1")
+	f.Add("", "", "", "", "99999999999999999999")
+
+	f.Fuzz(func(t *testing.T, firstName, lastName, document, birthdate, number string) {
+		bet, err := NewBetFromRecord("1", []string{firstName, lastName, document, birthdate, number})
+		if err != nil {
+			return
+		}
+		for _, field := range bet.Record() {
+			if !utf8.ValidString(field) {
+				t.Fatalf("bet %+v holds invalid UTF-8", bet)
+			}
+		}
+		decoded, err := DecodeBet(bet.Encode())
+		if err != nil {
+			t.Fatalf("could not decode %q: %v", bet.Encode(), err)
+		}
+		if !reflect.DeepEqual(bet, decoded) {
+			t.Fatalf("round trip mismatch: got %+v, want %+v", decoded, bet)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Directions of a captured frame
+const (
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// CaptureRecord A frame exchanged with the server. Conn numbers the
+// connections of the run so a replay opens them in the same order. Body
+// is kept as bytes, encoded in base64, as it may not be valid UTF-8
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Conn      int       `json:"conn"`
+	Direction string    `json:"direction"`
+	Type      string    `json:"type"`
+	Body      []byte    `json:"body"`
+}
+
+// Message Returns the captured frame as a protocol message
+func (r *CaptureRecord) Message() *Message {
+	return &Message{Type: r.Type, Body: r.Body}
+}
+
+// Capture Records every frame exchanged with the server to a file, one
+// JSON object per line. Records are written as soon as they happen, so
+// the capture is complete even if the client crashes
+type Capture struct {
+	file    *os.File
+	encoder *json.Encoder
+	conn    int
+}
+
+// NewCapture Creates the capture file located at path
+func NewCapture(path string) (*Capture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create capture file %s", path)
+	}
+	return &Capture{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// NextConn Starts recording the frames of a new connection
+func (c *Capture) NextConn() {
+	c.conn++
+}
+
+// Record Appends a frame sent or received on the current connection
+func (c *Capture) Record(direction string, msg *Message) error {
+	return c.encoder.Encode(&CaptureRecord{
+		Time:      time.Now(),
+		Conn:      c.conn,
+		Direction: direction,
+		Type:      msg.Type,
+		Body:      msg.Body,
+	})
+}
+
+// Close Closes the capture file
+func (c *Capture) Close() error {
+	return c.file.Close()
+}
+
+// ReadCapture Loads the records of the capture file located at path
+func ReadCapture(path string) ([]CaptureRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open capture file %s", path)
+	}
+	defer file.Close()
+
+	records := []CaptureRecord{}
+	scanner := bufio.NewScanner(file)
+	// A record holds at most a full payload, in base64
+	scanner.Buffer(make([]byte, 0, 64*1024), 8*MaxPayloadSize)
+	for line := 1; scanner.Scan(); line++ {
+		var record CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, errors.Wrapf(err, "%s:%d: invalid capture record", path, line)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "could not read capture file %s", path)
+	}
+	return records, nil
+}
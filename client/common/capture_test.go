@@ -0,0 +1,64 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sliceSource Batch source that returns the given batches
+type sliceSource [][]*Bet
+
+func (s *sliceSource) NextBatch() ([]*Bet, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	batch := (*s)[0]
+	*s = (*s)[1:]
+	return batch, nil
+}
+
+func TestCaptureAndReplayNonUTF8Frames(t *testing.T) {
+	// A Latin-1 "José" is not valid UTF-8
+	name := "Jos\xe9"
+	source := &sliceSource{
+		{{Agency: "1", FirstName: name, LastName: "Perez", Document: "30904465", Birthdate: "1990-01-01", Number: 7574}},
+		{{Agency: "1", FirstName: "Maria", LastName: "Perez", Document: "21689196", Birthdate: "1990-01-01", Number: 1234}},
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{newTestServer(t).address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     1,
+		CaptureFile:     path,
+	})
+	if err := client.UploadBatches(source); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("could not close capture: %v", err)
+	}
+
+	records, err := ReadCapture(path)
+	if err != nil {
+		t.Fatalf("could not read capture: %v", err)
+	}
+	if len(records) != 6 || records[0].Type != MsgBetBatch || !bytes.Contains(records[0].Body, []byte(name)) {
+		t.Fatalf("unexpected capture %+v", records)
+	}
+
+	// A new server answers the same frames with the same responses
+	report, err := Replay(newTestServer(t).address(), records)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if report.Sent != 3 || report.Received != 3 || len(report.Mismatches) != 0 {
+		var out bytes.Buffer
+		report.Write(&out)
+		t.Fatalf("unexpected replay:\n%s", out.String())
+	}
+}
@@ -0,0 +1,55 @@
+package common
+
+import (
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/transform"
+)
+
+// Charsets supported for agency files
+const (
+	CharsetUTF8   = "utf-8"
+	CharsetLatin1 = "latin1"
+)
+
+// latin1Decoder Transcodes ISO-8859-1 text to UTF-8. Every Latin-1 byte
+// maps to the unicode code point with the same value
+type latin1Decoder struct {
+	transform.NopResetter
+}
+
+func (latin1Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		b := src[nSrc]
+		if b < utf8.RuneSelf {
+			if nDst >= len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			dst[nDst] = b
+			nDst++
+		} else {
+			if nDst+2 > len(dst) {
+				return nDst, nSrc, transform.ErrShortDst
+			}
+			nDst += utf8.EncodeRune(dst[nDst:], rune(b))
+		}
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// newCharsetReader Wraps r so its content is read as UTF-8. An empty
+// charset is considered UTF-8
+func newCharsetReader(r io.Reader, charset string) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "", CharsetUTF8, "utf8":
+		return r, nil
+	case CharsetLatin1, "latin-1", "iso-8859-1":
+		return transform.NewReader(r, latin1Decoder{}), nil
+	default:
+		return nil, errors.Errorf("unsupported charset %q", charset)
+	}
+}
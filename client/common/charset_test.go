@@ -0,0 +1,91 @@
+package common
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readBets Reads every bet of an agency file with content encoded in
+// charset, and the rows rejected while reading it
+func readBets(t *testing.T, content string, charset string) ([]*Bet, []Rejection) {
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	reader, err := NewBatchReader("1", path, charset, 10)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	defer reader.Close()
+
+	bets := []*Bet{}
+	for {
+		batch, err := reader.NextBatch()
+		if err == io.EOF {
+			return bets, reader.Rejected()
+		}
+		if err != nil {
+			t.Fatalf("could not read agency file: %v", err)
+		}
+		bets = append(bets, batch...)
+	}
+}
+
+func TestLatin1FileIsTranscoded(t *testing.T) {
+	// "Peña" and "Müller" as ISO-8859-1 bytes
+	content := "Jos\xe9,Pe\xf1a,30904465,1999-03-17,7574\nJ\xfcrgen,M\xfcller,30904466,1999-03-17,1\n"
+	for _, charset := range []string{CharsetLatin1, "ISO-8859-1", "latin-1"} {
+		bets, rejected := readBets(t, content, charset)
+		if len(bets) != 2 || len(rejected) != 0 {
+			t.Fatalf("%s: read %d bets and %d rejections, want 2 and none", charset, len(bets), len(rejected))
+		}
+		if bets[0].FirstName != "José" || bets[0].LastName != "Peña" || bets[1].LastName != "Müller" {
+			t.Fatalf("%s: names transcoded as %q %q and %q", charset, bets[0].FirstName, bets[0].LastName, bets[1].LastName)
+		}
+	}
+}
+
+func TestInvalidUTF8RowIsRejected(t *testing.T) {
+	// The same Latin-1 row read as UTF-8
+	content := "Jos\xe9,Lorca,30904465,1999-03-17,7574\nSantiago,Lorca,30904466,1999-03-17,1\n"
+	bets, rejected := readBets(t, content, CharsetUTF8)
+	if len(bets) != 1 || bets[0].Document != "30904466" {
+		t.Fatalf("read %d bets, want only the valid one", len(bets))
+	}
+	if len(rejected) != 1 || rejected[0].Line != 1 || !strings.Contains(rejected[0].Reason, "invalid UTF-8") {
+		t.Fatalf("unexpected rejections %+v", rejected)
+	}
+}
+
+func TestUnsupportedCharsetFails(t *testing.T) {
+	if _, err := newCharsetReader(strings.NewReader(""), "utf-16"); err == nil {
+		t.Fatalf("expected an error for an unsupported charset")
+	}
+}
+
+func TestComposedAndDecomposedNamesAreEqual(t *testing.T) {
+	composed := []string{"José", "Peña", "30904465", "1999-03-17", "7574"}
+	decomposed := []string{"Jose\u0301", "Pen\u0303a", "30904465", "1999-03-17", "7574"}
+
+	first, err := NewBetFromRecord("1", composed)
+	if err != nil {
+		t.Fatalf("could not build bet: %v", err)
+	}
+	second, err := NewBetFromRecord("1", decomposed)
+	if err != nil {
+		t.Fatalf("could not build bet: %v", err)
+	}
+	if first.Encode() != second.Encode() {
+		t.Fatalf("encoded bets differ: %q and %q", first.Encode(), second.Encode())
+	}
+}
+
+func TestNewBetFromRecordRejectsInvalidUTF8(t *testing.T) {
+	_, err := NewBetFromRecord("1", []string{"Jos\xe9", "Lorca", "30904465", "1999-03-17", "7574"})
+	if err == nil || !strings.Contains(err.Error(), "invalid UTF-8") {
+		t.Fatalf("got %v, want an invalid UTF-8 error", err)
+	}
+}
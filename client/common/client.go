@@ -3,87 +3,730 @@ package common
 import (
 	"bufio"
 	"fmt"
+	"math/rand"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/op/go-logging"
+	"github.com/pkg/errors"
 )
 
 var log = logging.MustGetLogger("log")
 
+// maxRetryAfter Upper bound for the pause requested by a BUSY response
+const maxRetryAfter = time.Minute
+
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
-	ID            string
-	ServerAddress string
-	LoopAmount    int
-	LoopPeriod    time.Duration
+	ID string
+	// Contest Draw the bets of the client take part in. Empty uses
+	// DefaultContest
+	Contest string
+	// ServerAddresses Central servers the client connects to, picked
+	// according to ServerPolicy. A failing server is not tried again
+	// until ServerEjectFor has elapsed, unless every server is failing
+	ServerAddresses []string
+	ServerPolicy    string
+	ServerEjectFor  time.Duration
+	// ResponseTimeout Maximum time to connect to a server and to wait for
+	// each of its responses. 0 waits forever
+	ResponseTimeout time.Duration
+	Heartbeat       HeartbeatConfig
+	LoopAmount      int
+	LoopPeriod      time.Duration
+	BatchMaxAmount  int
+	// BetsCharset Encoding of the agency files, UTF-8 or Latin-1
+	BetsCharset  string
+	BatchWindow  int
+	BatchRetries int
+	RateLimit    RateLimitConfig
+	Validation   ValidationConfig
+	Duplicates   DuplicateConfig
+	// DeadLetterFile CSV file where rejected rows are written. Empty
+	// disables it
+	DeadLetterFile string
+	// WinnersCSV and WinnersJSON Files where the winners are exported.
+	// Empty disables the format
+	WinnersCSV  string
+	WinnersJSON string
+	// RequireDigest Fails the upload if the server answers BATCH_END
+	// without its digest. Otherwise the digest check is skipped
+	RequireDigest bool
+	// WinnersWait Maximum time to wait for the server to push the
+	// winners after WAIT_WINNERS. 0 only polls with GET_WINNERS
+	WinnersWait time.Duration
+	// VerifyWinners Checks the winners returned by the server against
+	// the bets of the agency file for the number of the DRAW message of
+	// the server or, if it sends none, WinningNumber. The same number
+	// selects the bets of the winners exported. A negative WinningNumber
+	// is unknown
+	VerifyWinners bool
+	WinningNumber int
+	// SummaryFile JSON file where the summary of the run is written.
+	// Empty disables it
+	SummaryFile string
+	// CaptureFile File where every frame exchanged with the server is
+	// recorded. Empty disables the capture
+	CaptureFile string
+	// Faults Faults injected on the connections, for testing purposes
+	Faults FaultConfig
 }
 
 // Client Entity that encapsulates how
 type Client struct {
-	config ClientConfig
-	conn   net.Conn
+	config    ClientConfig
+	endpoints *EndpointPool
+	// address Server of the current connection
+	address string
+	// connMu Guards the connection against Shutdown, which may close it
+	// from another goroutine
+	connMu  sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	limiter *RateLimiter
+	// validator Rules checked on every bet read from the agency file
+	validator *Validator
+	// backpressure Total time paused because the server was busy
+	backpressure time.Duration
+	// onAck and onRetry are optional hooks called by the batch sender
+	// when a batch is acknowledged and when a failed attempt is retried
+	onAck   func(bets []*Bet, latency time.Duration)
+	onRetry func()
+	// deadLetter Destination of the rows rejected during an upload
+	deadLetter *DeadLetter
+	// rejectedBets Amount of bets refused by the server and
+	// rejectedLines the lines of the agency file they were read from
+	rejectedBets  int
+	rejectedLines map[int]bool
+	// drawNumber Winning number sent by the server, if drawKnown
+	drawNumber int
+	drawKnown  bool
+	// stats Counters reported in the summary of the run
+	stats runStats
+	// digest Digests of the batches acknowledged by the server
+	digest uploadDigest
+	// capture Records the frames exchanged with the server, if enabled
+	capture *Capture
+	// faultRand Random source of the injected faults, if enabled
+	faultRand *rand.Rand
+	// stop Closed by Shutdown
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewClient Initializes a new client receiving the configuration
 // as a parameter
 func NewClient(config ClientConfig) *Client {
+	if config.Contest == "" {
+		config.Contest = DefaultContest
+	}
 	client := &Client{
-		config: config,
+		config:    config,
+		endpoints: NewEndpointPool(config.ServerAddresses, config.ServerPolicy, config.ServerEjectFor),
+		limiter:   NewRateLimiter(config.RateLimit),
+		validator: NewValidator(config.Validation, time.Now()),
+		stats:     runStats{started: time.Now()},
+		stop:      make(chan struct{}),
+		// Bets refused by the server are not winners of the agency
+		rejectedLines: map[int]bool{},
+	}
+	if config.Faults.Enabled() {
+		log.Warningf("action: fault_injection | result: in_progress | client_id: %v | faults: %+v", config.ID, config.Faults)
+		client.faultRand = newFaultRand(config.Faults)
 	}
 	return client
 }
 
-// CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
+// CreateClientSocket Initializes client socket, trying every server in
+// the order given by the endpoint pool. Servers that cannot be reached
+// are ejected. In case no server is reachable, error is printed in
+// stdout/stderr and returned
 func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
-	if err != nil {
-		log.Criticalf(
-			"action: connect | result: fail | client_id: %v | error: %v",
+	if c.Stopped() {
+		return ErrShutdown
+	}
+	if c.config.CaptureFile != "" && c.capture == nil {
+		capture, err := NewCapture(c.config.CaptureFile)
+		if err != nil {
+			return err
+		}
+		c.capture = capture
+	}
+
+	err := errors.New("no server address configured")
+	for _, address := range c.endpoints.Candidates() {
+		var conn net.Conn
+		// An unreachable server must not delay the next candidates
+		dialer := net.Dialer{Timeout: c.config.ResponseTimeout, KeepAlive: c.config.Heartbeat.KeepAlive}
+		conn, err = dialer.Dial("tcp", address)
+		if err != nil {
+			c.ejectServer(address, err)
+			continue
+		}
+		c.endpoints.MarkSuccess(address)
+		log.Debugf("action: connect | result: success | client_id: %v | server: %v", c.config.ID, address)
+		c.address = address
+		if c.faultRand != nil {
+			conn = NewFaultyConn(conn, c.config.Faults, c.faultRand)
+		}
+		c.connMu.Lock()
+		if c.Stopped() {
+			c.connMu.Unlock()
+			conn.Close()
+			return ErrShutdown
+		}
+		c.conn = &countingConn{Conn: conn, stats: &c.stats}
+		c.reader = bufio.NewReader(c.conn)
+		c.connMu.Unlock()
+		if c.capture != nil {
+			c.capture.NextConn()
+		}
+		return nil
+	}
+
+	log.Criticalf(
+		"action: connect | result: fail | client_id: %v | error: %v",
+		c.config.ID,
+		err,
+	)
+	return err
+}
+
+// ejectServer Reports a failure of the server at address so the next
+// connections prefer the other servers
+func (c *Client) ejectServer(address string, cause error) {
+	c.endpoints.MarkFailure(address)
+	if c.endpoints.Len() > 1 {
+		log.Warningf("action: server_eject | result: success | client_id: %v | server: %v | eject_for: %v | error: %v",
 			c.config.ID,
-			err,
+			address,
+			c.config.ServerEjectFor,
+			cause,
 		)
 	}
-	c.conn = conn
+}
+
+// closeClientSocket Closes the connection with the server, if any
+func (c *Client) closeClientSocket() {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if c.conn == nil {
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+}
+
+// Close Releases the resources held by the client
+func (c *Client) Close() error {
+	c.closeClientSocket()
+	if c.capture == nil {
+		return nil
+	}
+	err := c.capture.Close()
+	c.capture = nil
+	return err
+}
+
+// send Writes a message to the current connection, recording it if the
+// capture is enabled
+func (c *Client) send(msg *Message) error {
+	if err := SendMessage(c.conn, msg); err != nil {
+		return err
+	}
+	c.record(DirectionSent, msg)
 	return nil
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
-	// There is an autoincremental msgID to identify every message sent
-	// Messages if the message amount threshold has not been surpassed
-	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
+// receive Reads a message from the current connection, recording it if
+// the capture is enabled. Answers to heartbeats are skipped
+func (c *Client) receive() (*Message, error) {
+	return c.receiveUntil(time.Time{})
+}
+
+// receiveUntil Reads a message like receive, waiting for it up to until
+// instead of ResponseTimeout. A zero until waits as receive does
+func (c *Client) receiveUntil(until time.Time) (*Message, error) {
+	// The deadline covers the whole wait, so a server that only answers
+	// heartbeats does not extend it
+	if until.IsZero() && c.config.ResponseTimeout > 0 {
+		until = time.Now().Add(c.config.ResponseTimeout)
+	}
+	for {
+		if err := c.awaitResponse(until); err != nil {
+			return nil, err
+		}
+		c.conn.SetReadDeadline(until)
+
+		msg, err := ReceiveMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		c.record(DirectionReceived, msg)
+		if msg.Type != MsgPong {
+			return msg, nil
+		}
+	}
+}
+
+// record Appends a frame to the capture, if enabled. A capture failure
+// disables it without affecting the run
+func (c *Client) record(direction string, msg *Message) {
+	if c.capture == nil {
+		return
+	}
+	if err := c.capture.Record(direction, msg); err != nil {
+		log.Errorf("action: capture | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		c.capture.Close()
+		c.capture = nil
+	}
+}
 
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
+// exchange Sends a message to the server and waits for its response.
+// While the server answers BUSY the message is sent again after the
+// requested pause, without considering it a failure
+func (c *Client) exchange(msg *Message) (*Message, error) {
+	for {
+		if err := c.send(msg); err != nil {
+			return nil, errors.Wrapf(err, "could not send %s", msg.Type)
+		}
+		response, err := c.receive()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not receive response to %s", msg.Type)
+		}
+		if response.Type != MsgBusy {
+			return response, nil
+		}
+		c.waitBackpressure(response)
+	}
+}
+
+// waitBackpressure Pauses the client for the time requested by a BUSY
+// response. If the response has no valid hint LoopPeriod is used
+func (c *Client) waitBackpressure(response *Message) {
+	retryAfter, ok := DecodeRetryAfter(response.Body)
+	if !ok {
+		retryAfter = c.config.LoopPeriod
+	}
+	if retryAfter > maxRetryAfter {
+		retryAfter = maxRetryAfter
+	}
+
+	log.Infof("action: backpressure | result: in_progress | client_id: %v | retry_after: %v",
+		c.config.ID,
+		retryAfter,
+	)
+	c.backpressure += retryAfter
+	c.pause(retryAfter)
+}
+
+// expectAck Returns an error unless the response is an ACK
+func expectAck(response *Message) error {
+	if response.Type == MsgAck {
+		return nil
+	}
+	if response.Type == MsgError {
+		return errors.Errorf("server error: %s", response.Body)
+	}
+	return errors.Errorf("unexpected response %s", response.Type)
+}
+
+// SendBets Uploads every bet of the agency file located at path in
+// batches and notifies the server once the upload has finished
+func (c *Client) SendBets(path string) error {
+	batches, err := NewBatchReader(c.config.ID, path, c.config.BetsCharset, c.config.BatchMaxAmount)
+	if err != nil {
+		return err
+	}
+	defer batches.Close()
+	batches.SetValidator(c.validator)
+	defer c.validator.LogSummary(c.config.ID)
+	if err := c.detectDuplicates(batches, path); err != nil {
+		return err
+	}
+
+	if c.config.DeadLetterFile != "" {
+		if c.deadLetter, err = NewDeadLetter(c.config.DeadLetterFile); err != nil {
+			return err
+		}
+		defer c.closeDeadLetter()
+	}
+
+	err = c.UploadBatches(batches)
+	c.stats.file = path
+	c.stats.rowsRead = batches.Line()
+	c.stats.rowsRejected = len(batches.Rejected()) + c.rejectedBets
+	c.stats.duplicates = batches.Duplicates()
+	for _, rejection := range batches.Rejected() {
+		c.addDeadLetter(rejection.Line, rejection.Reason, rejection.Record)
+	}
+	if rejected := len(batches.Rejected()) + c.rejectedBets; rejected > 0 {
+		log.Warningf("action: apuestas_rechazadas | result: success | client_id: %v | cantidad: %v | locales: %v | servidor: %v",
 			c.config.ID,
-			msgID,
+			rejected,
+			len(batches.Rejected()),
+			c.rejectedBets,
 		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		c.conn.Close()
+	}
+	return err
+}
 
+// detectDuplicates Makes batches apply the duplicates policy to the bets
+// repeated in the agency file located at path, if a policy is set. With
+// the fail policy the whole file is checked first, so a repeated bet
+// aborts the upload before the client connects
+func (c *Client) detectDuplicates(batches *BatchReader, path string) error {
+	if c.config.Duplicates.Policy == "" {
+		return nil
+	}
+	// Invalid rows are never uploaded, so they cannot be repeated
+	detector, err := newDuplicateDetector(c.config.Duplicates, c.config.ID, path, c.config.BetsCharset, NewValidator(c.config.Validation, time.Now()))
+	if err != nil {
+		return err
+	}
+	if c.config.Duplicates.Policy == DuplicateFail {
+		reader, err := NewBatchReader(c.config.ID, path, c.config.BetsCharset, 1)
 		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
+			return err
+		}
+		defer reader.Close()
+		reader.SetValidator(NewValidator(c.config.Validation, time.Now()))
+		if dupErr := detector.precheck(reader); dupErr != nil {
+			log.Errorf("action: apuesta_duplicada | result: success | client_id: %v | line: %v | first_line: %v | dni: %v | numero: %v | policy: %v",
 				c.config.ID,
-				err,
+				dupErr.Line,
+				dupErr.FirstLine,
+				dupErr.Document,
+				dupErr.Number,
+				DuplicateFail,
 			)
-			return
+			return errors.Wrapf(dupErr, "line %d", dupErr.Line)
 		}
+	}
+	batches.setDuplicateDetector(detector)
+	return nil
+}
+
+// rejectBet Reports a bet refused by the server
+func (c *Client) rejectBet(bet *Bet, reason string) {
+	c.rejectedBets++
+	c.rejectedLines[bet.Line] = true
+	log.Warningf("action: apuesta_rechazada | result: success | client_id: %v | line: %v | dni: %v | numero: %v | reason: %v",
+		c.config.ID,
+		bet.Line,
+		bet.Document,
+		bet.Number,
+		reason,
+	)
+	c.addDeadLetter(bet.Line, reason, bet.Record())
+}
+
+// addDeadLetter Writes a rejected row to the dead letter file, if any
+func (c *Client) addDeadLetter(line int, reason string, record []string) {
+	if c.deadLetter == nil {
+		return
+	}
+	if err := c.deadLetter.Add(line, reason, record); err != nil {
+		log.Errorf("action: dead_letter | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	}
+}
+
+// closeDeadLetter Closes the dead letter file and logs where the
+// rejected rows were written
+func (c *Client) closeDeadLetter() {
+	if err := c.deadLetter.Close(); err != nil {
+		log.Errorf("action: dead_letter | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	} else if c.deadLetter.Count() > 0 {
+		log.Infof("action: dead_letter | result: success | client_id: %v | file: %v | cantidad: %v",
+			c.config.ID,
+			c.config.DeadLetterFile,
+			c.deadLetter.Count(),
+		)
+	}
+	c.deadLetter = nil
+}
+
+// UploadBatches Sends every batch provided by source through a single
+// connection, keeping up to BatchWindow batches in flight, and notifies
+// the server once the upload has finished
+func (c *Client) UploadBatches(source BatchSource) error {
+	if err := c.createClientSocket(); err != nil {
+		return err
+	}
+	defer c.closeClientSocket()
 
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
+	if err := newWindowSender(c, source).run(); err != nil {
+		return err
+	}
+
+	if throttled, waits := c.limiter.Throttled(); waits > 0 {
+		log.Infof("action: rate_limit | result: success | client_id: %v | throttled: %v | batches_delayed: %v",
 			c.config.ID,
-			msg,
+			throttled,
+			waits,
 		)
+	}
+	return c.sendBatchEnd()
+}
 
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+// sendBatchEnd Notifies the server that the agency finished its upload,
+// sending the digest of every bet acknowledged, and checks the digest the
+// server answers with. If the exchange fails it is retried on a new
+// connection up to BatchRetries times, so the server must accept a
+// repeated BATCH_END
+func (c *Client) sendBatchEnd() error {
+	var err error
+	var response *Message
+	for attempt := 0; attempt <= c.config.BatchRetries; attempt++ {
+		if c.Stopped() {
+			err = ErrShutdown
+			break
+		}
+		if attempt > 0 {
+			log.Warningf("action: batch_end | result: fail | client_id: %v | attempt: %v | error: %v", c.config.ID, attempt, err)
+			c.stats.retries++
+			if c.conn != nil {
+				c.ejectServer(c.address, err)
+			}
+			c.closeClientSocket()
+			if err = c.pause(c.config.LoopPeriod); err != nil {
+				break
+			}
+			if err = c.createClientSocket(); err != nil {
+				continue
+			}
+			c.stats.reconnections++
+		}
+
+		response, err = c.exchange(NewBatchEndMessage(c.config.ID, c.config.Contest, c.digest.total()))
+		if err != nil {
+			continue
+		}
+		// Retrying does not help if the server refused the message
+		if err = expectAck(response); err == nil || response.Type == MsgError {
+			break
+		}
+	}
 
+	if err != nil {
+		log.Errorf("action: batch_end | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
 	}
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+	log.Infof("action: batch_end | result: success | client_id: %v", c.config.ID)
+	return c.verifyDigest(response)
+}
+
+// QueryWinners Requests the winners of the agency and returns all of
+// them. See StreamWinners
+func (c *Client) QueryWinners() ([]string, error) {
+	winners := []string{}
+	err := c.StreamWinners(func(documents []string) error {
+		winners = append(winners, documents...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return winners, nil
+}
+
+// StreamWinners Requests the winners of the agency, calling handle with
+// the documents of every frame as it arrives, so the whole list is never
+// held in memory. If WinnersWait is set the client first waits for the
+// server to push them. Otherwise, or if the server does not support it,
+// the request is repeated up to LoopAmount times while the draw has not
+// taken place, waiting LoopPeriod between attempts
+func (c *Client) StreamWinners(handle func(documents []string) error) error {
+	received := 0
+	count := func(documents []string) error {
+		received += len(documents)
+		return handle(documents)
+	}
+
+	if c.config.WinnersWait > 0 {
+		err := c.waitWinners(count)
+		if err == nil {
+			c.winnersReceived(received)
+			return nil
+		}
+		if c.Stopped() {
+			return ErrShutdown
+		}
+		// The winners already handled would be handled twice by polling
+		if received > 0 {
+			log.Errorf("action: esperar_ganadores | result: fail | client_id: %v | recibidos: %v | error: %v", c.config.ID, received, err)
+			return err
+		}
+		log.Warningf("action: esperar_ganadores | result: fail | client_id: %v | error: %v | fallback: polling", c.config.ID, err)
+	}
+
+	for attempt := 1; attempt <= c.config.LoopAmount; attempt++ {
+		if err := c.createClientSocket(); err != nil {
+			return err
+		}
+		response, err := c.exchange(NewSessionMessage(MsgGetWinners, c.config.ID, c.config.Contest))
+		if err == nil && response.Type == MsgDraw {
+			response, err = c.receiveDraw(response)
+		}
+		if err == nil && isWinners(response) {
+			err = c.receiveWinners(response, count)
+		}
+		c.closeClientSocket()
+
+		if err != nil && c.Stopped() {
+			return ErrShutdown
+		}
+		if err != nil {
+			log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+
+		switch {
+		case isWinners(response):
+			c.winnersReceived(received)
+			return nil
+		case response.Type == MsgError && string(response.Body) == ErrNotAllBatchesReceived:
+			log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v | attempt: %v",
+				c.config.ID,
+				attempt,
+			)
+			if err := c.pause(c.config.LoopPeriod); err != nil {
+				return err
+			}
+		default:
+			err := expectAck(response)
+			if err == nil {
+				err = errors.Errorf("unexpected response %s", response.Type)
+			}
+			log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+	}
+
+	err := errors.Errorf("draw not available after %d attempts", c.config.LoopAmount)
+	log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	return err
+}
+
+// isWinners Returns true if response carries winners, either all of them
+// or the first part
+func isWinners(response *Message) bool {
+	return response.Type == MsgWinners || response.Type == MsgWinnersPart
+}
+
+// receiveWinners Hands the winners of response to handle. A WINNERS_PART
+// response is followed by more frames on the same connection, until the
+// last part arrives as WINNERS
+func (c *Client) receiveWinners(response *Message, handle func(documents []string) error) error {
+	for part := 1; ; part++ {
+		if err := handle(DecodeWinners(response.Body)); err != nil {
+			return err
+		}
+		if response.Type == MsgWinners {
+			return nil
+		}
+		log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v | parte: %v", c.config.ID, part)
+
+		next, err := c.receive()
+		if err != nil {
+			return errors.Wrapf(err, "winners interrupted after %d parts", part)
+		}
+		if !isWinners(next) {
+			return errors.Errorf("unexpected response %s after %d parts of winners", next.Type, part)
+		}
+		response = next
+	}
+}
+
+// waitWinners Subscribes to the draw with WAIT_WINNERS and waits up to
+// WinnersWait for the server to push the winners of the agency, handing
+// them to handle. Heartbeats keep running meanwhile, so a dead server is
+// detected before WinnersWait elapses
+func (c *Client) waitWinners(handle func(documents []string) error) error {
+	if err := c.createClientSocket(); err != nil {
+		return err
+	}
+	defer c.closeClientSocket()
+
+	msg := NewSessionMessage(MsgWaitWinners, c.config.ID, c.config.Contest)
+	if err := c.send(msg); err != nil {
+		return errors.Wrapf(err, "could not send %s", msg.Type)
+	}
+	log.Infof("action: esperar_ganadores | result: in_progress | client_id: %v | max_wait: %v", c.config.ID, c.config.WinnersWait)
+
+	deadline := time.Now().Add(c.config.WinnersWait)
+	for {
+		response, err := c.receiveUntil(deadline)
+		if err != nil {
+			return errors.Wrap(err, "winners not pushed")
+		}
+
+		switch response.Type {
+		case MsgWinners, MsgWinnersPart:
+			return c.receiveWinners(response, handle)
+		case MsgDraw:
+			response, err := c.receiveDraw(response)
+			if err != nil {
+				return errors.Wrap(err, "winners not pushed")
+			}
+			if !isWinners(response) {
+				return errors.Errorf("unexpected response %s after %s", response.Type, MsgDraw)
+			}
+			return c.receiveWinners(response, handle)
+		case MsgBusy:
+			c.waitBackpressure(response)
+			if err := c.send(msg); err != nil {
+				return errors.Wrapf(err, "could not send %s", msg.Type)
+			}
+		default:
+			return errors.Errorf("%s not supported, got %s %q", msg.Type, response.Type, response.Body)
+		}
+	}
+}
+
+// receiveDraw Records the winning number of a DRAW response and returns
+// the response that follows it
+func (c *Client) receiveDraw(response *Message) (*Message, error) {
+	number, ok := DecodeDraw(response.Body)
+	if !ok {
+		return nil, errors.Errorf("invalid winning number %q", response.Body)
+	}
+	c.drawNumber, c.drawKnown = number, true
+	log.Debugf("action: sorteo | result: success | client_id: %v | numero: %v", c.config.ID, number)
+	return c.receive()
+}
+
+// winnersReceived Records and logs the amount of winners of the agency
+func (c *Client) winnersReceived(winners int) {
+	c.stats.winners = winners
+	log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v", winners)
+}
+
+// Probe Performs a single round trip against the server: a line is
+// sent and the same line is expected back
+func (c *Client) Probe() error {
+	if err := c.createClientSocket(); err != nil {
+		return err
+	}
+	defer c.closeClientSocket()
+
+	msg := fmt.Sprintf("[CLIENT %v] probe %v", c.config.ID, time.Now().UnixNano())
+	if err := writeAll(c.conn, []byte(msg+"\n")); err != nil {
+		return err
+	}
+	// A server that never answers must not hang the probe
+	if c.config.ResponseTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.config.ResponseTimeout))
+	}
+	echo, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(echo, "\n") != msg {
+		return errors.Errorf("unexpected echo %q", echo)
+	}
+	return nil
 }
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendBetsUploadsEveryBet(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+	})
+
+	if err := client.SendBets(writeAgencyFile(t, sequentialBets(25))); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, finished := server.stored("1"); stored != 25 || !finished {
+		t.Fatalf("server stored %d of 25 bets (finished: %v)", stored, finished)
+	}
+}
+
+func TestSendBetsSkipsInvalidRows(t *testing.T) {
+	server := newTestServer(t)
+	path := writeAgencyFile(t, sequentialBets(5))
+	appendRows(t, path, "Nombre,Apellido,30000006,1990-01-01,seis\n")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+	})
+
+	if err := client.SendBets(path); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, finished := server.stored("1"); stored != 5 || !finished {
+		t.Fatalf("server stored %d of 5 bets (finished: %v)", stored, finished)
+	}
+}
+
+func TestSendBetsSkipsBetsBreakingRules(t *testing.T) {
+	server := newTestServer(t)
+	path := writeAgencyFile(t, [][2]int{{30904465, 1}, {12, 2}, {30904466, 3}})
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		Validation:      ValidationConfig{DocumentEnabled: true, DocumentMinDigits: 7, DocumentMaxDigits: 8},
+	})
+
+	if err := client.SendBets(path); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, _ := server.stored("1"); stored != 2 || client.validator.Failures()["document"] != 1 {
+		t.Fatalf("server stored %d bets with failures %v, want 2", stored, client.validator.Failures())
+	}
+}
+
+func TestQueryWinnersPollsUntilDraw(t *testing.T) {
+	server := newTestServer(t)
+	time.AfterFunc(50*time.Millisecond, server.draw)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopAmount:      20,
+		LoopPeriod:      10 * time.Millisecond,
+	})
+
+	winners, err := client.QueryWinners()
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(winners) != 2 || winners[0] != "30904465" {
+		t.Fatalf("unexpected winners %v", winners)
+	}
+}
+
+func TestQueryWinnersGivesUp(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopAmount:      3,
+		LoopPeriod:      time.Millisecond,
+	})
+
+	if _, err := client.QueryWinners(); err == nil {
+		t.Fatalf("expected the query to fail without a draw")
+	}
+}
@@ -0,0 +1,543 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConformanceConfig Parameters of the conformance suite
+type ConformanceConfig struct {
+	Address string
+	// Contest Contest the bets of the suite take part in. Empty uses
+	// DefaultContest
+	Contest string
+	// FirstAgency and Agencies Agency IDs used by the suite. The draw only
+	// takes place once every agency expected by the server has finished,
+	// so Agencies should match the server configuration
+	FirstAgency int
+	Agencies    int
+	// Timeout Maximum time to wait for each response
+	Timeout time.Duration
+	// WinnersWait Maximum time to wait for the draw once every agency
+	// finished its upload
+	WinnersWait time.Duration
+}
+
+// ConformanceResult Outcome of a case of the suite
+type ConformanceResult struct {
+	Name     string
+	Passed   bool
+	Detail   string
+	Duration time.Duration
+}
+
+// conformanceCase A check of the protocol. run returns a description of
+// the behavior observed when the check passes
+type conformanceCase struct {
+	name string
+	run  func(s *conformanceSuite) (string, error)
+}
+
+// conformanceCases Cases in the order they are run. Cases that need the
+// upload of the agencies to be unfinished run first
+var conformanceCases = []conformanceCase{
+	{"single_bet", (*conformanceSuite).singleBet},
+	{"batch_at_size_limit", (*conformanceSuite).batchAtSizeLimit},
+	{"oversized_frame", (*conformanceSuite).oversizedFrame},
+	{"malformed_bet", (*conformanceSuite).malformedBet},
+	{"early_winners_query", (*conformanceSuite).earlyWinnersQuery},
+	{"concurrent_agencies", (*conformanceSuite).concurrentAgencies},
+	{"batch_end_twice", (*conformanceSuite).batchEndTwice},
+	{"digest_query", (*conformanceSuite).digestQuery},
+	{"winners_after_draw", (*conformanceSuite).winnersAfterDraw},
+}
+
+// conformanceSuite State shared by the cases: the next sequence number of
+// every agency, so batches are never repeated across cases, and the
+// digests of the batches acknowledged
+type conformanceSuite struct {
+	config  ConformanceConfig
+	mu      sync.Mutex
+	seqs    map[string]int
+	digests map[string]*uploadDigest
+	source  *SyntheticBatchSource
+}
+
+// RunConformance Runs every case of the suite against the server. A case
+// failing does not stop the suite
+func RunConformance(config ConformanceConfig) []ConformanceResult {
+	if config.Agencies <= 0 {
+		config.Agencies = 1
+	}
+	if config.Contest == "" {
+		config.Contest = DefaultContest
+	}
+	suite := &conformanceSuite{
+		config:  config,
+		seqs:    map[string]int{},
+		digests: map[string]*uploadDigest{},
+		source:  NewSyntheticBatchSource("", 1<<30, 1, int64(config.FirstAgency)),
+	}
+
+	results := make([]ConformanceResult, 0, len(conformanceCases))
+	for _, c := range conformanceCases {
+		start := time.Now()
+		detail, err := c.run(suite)
+		result := ConformanceResult{Name: c.name, Passed: err == nil, Detail: detail, Duration: time.Since(start)}
+		if err != nil {
+			result.Detail = err.Error()
+		}
+		level := log.Infof
+		if !result.Passed {
+			level = log.Warningf
+		}
+		level("action: conformance_case | result: %v | case: %v | detail: %v", passText(result.Passed), c.name, result.Detail)
+		results = append(results, result)
+	}
+	return results
+}
+
+func passText(passed bool) string {
+	if passed {
+		return "success"
+	}
+	return "fail"
+}
+
+// WriteConformanceReport Prints a line per case followed by the totals
+func WriteConformanceReport(w io.Writer, results []ConformanceResult) {
+	passed := 0
+	for _, result := range results {
+		status := "FAIL"
+		if result.Passed {
+			status = "PASS"
+			passed++
+		}
+		fmt.Fprintf(w, "%-4s  %-22s %8s  %s\n", status, result.Name, result.Duration.Round(time.Millisecond), result.Detail)
+	}
+	fmt.Fprintf(w, "%d/%d cases passed\n", passed, len(results))
+}
+
+// agency Returns the ID of the i-th agency of the suite
+func (s *conformanceSuite) agency(i int) string {
+	return strconv.Itoa(s.config.FirstAgency + i)
+}
+
+// nextSeq Reserves the next sequence number of agency
+func (s *conformanceSuite) nextSeq(agency string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqs[agency]++
+	return s.seqs[agency]
+}
+
+// releaseSeq Gives back the last sequence number of agency, used by a
+// batch the server did not store
+func (s *conformanceSuite) releaseSeq(agency string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seqs[agency]--
+}
+
+// acked Records the bets of the batch seq of agency, acknowledged by the
+// server
+func (s *conformanceSuite) acked(agency string, seq int, bets []*Bet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.digests[agency] == nil {
+		s.digests[agency] = &uploadDigest{}
+	}
+	s.digests[agency].ack(seq, bets)
+}
+
+// digest Returns the digest of the batches of agency up to last
+func (s *conformanceSuite) digest(agency string, last int) Digest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.digests[agency] == nil {
+		return Digest{}
+	}
+	return s.digests[agency].rangeDigest(1, last)
+}
+
+// lastSeq Returns the last sequence number used by agency
+func (s *conformanceSuite) lastSeq(agency string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seqs[agency]
+}
+
+// bets Generates amount valid bets of agency
+func (s *conformanceSuite) bets(agency string, amount int) []*Bet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bets := make([]*Bet, 0, amount)
+	for i := 0; i < amount; i++ {
+		batch, _ := s.source.NextBatch()
+		batch[0].Agency = agency
+		bets = append(bets, batch[0])
+	}
+	return bets
+}
+
+// conformanceConn A connection to the server under test where every read
+// is bounded by the suite timeout
+type conformanceConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+func (s *conformanceSuite) dial() (*conformanceConn, error) {
+	conn, err := net.DialTimeout("tcp", s.config.Address, s.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conformanceConn{conn: conn, reader: bufio.NewReader(conn), timeout: s.config.Timeout}, nil
+}
+
+func (c *conformanceConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *conformanceConn) receive() (*Message, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return ReceiveMessage(c.reader)
+}
+
+func (c *conformanceConn) exchange(msg *Message) (*Message, error) {
+	if err := SendMessage(c.conn, msg); err != nil {
+		return nil, errors.Wrapf(err, "could not send %s", msg.Type)
+	}
+	response, err := c.receive()
+	if err != nil {
+		return nil, errors.Wrapf(err, "no response to %s", msg.Type)
+	}
+	return response, nil
+}
+
+// expectAckOf Checks that response acknowledges the batch seq
+func expectAckOf(response *Message, seq int) error {
+	if response.Type != MsgAck {
+		return errors.Errorf("expected ACK %d, got %s %q", seq, response.Type, response.Body)
+	}
+	if got, ok := DecodeAckSeq(response.Body); !ok || got != seq {
+		return errors.Errorf("expected ACK %d, got ACK %q", seq, response.Body)
+	}
+	return nil
+}
+
+// sendBatch Sends a batch of agency on conn and expects its ACK
+func (s *conformanceSuite) sendBatch(conn *conformanceConn, agency string, bets []*Bet) error {
+	seq := s.nextSeq(agency)
+	response, err := conn.exchange(NewBatchMessage(s.config.Contest, seq, bets))
+	if err != nil {
+		return err
+	}
+	if err := expectAckOf(response, seq); err != nil {
+		return err
+	}
+	s.acked(agency, seq, bets)
+	return nil
+}
+
+// expectDigest Checks that response carries want as a digest of type
+// msgType
+func expectDigest(response *Message, msgType string, want Digest) error {
+	if response.Type != msgType {
+		return errors.Errorf("expected %s %v, got %s %q", msgType, want, response.Type, response.Body)
+	}
+	got, err := ParseDigest(string(response.Body))
+	if err != nil {
+		return errors.Wrapf(err, "expected %s %v", msgType, want)
+	}
+	if got != want {
+		return errors.Errorf("expected %s %v, got %v", msgType, want, got)
+	}
+	return nil
+}
+
+// batchEnd Sends BATCH_END for agency with the digest of its batches and
+// expects the server to acknowledge it with the same digest
+func (s *conformanceSuite) batchEnd(conn *conformanceConn, agency string) error {
+	digest := s.digest(agency, s.lastSeq(agency))
+	response, err := conn.exchange(NewBatchEndMessage(agency, s.config.Contest, digest))
+	if err != nil {
+		return err
+	}
+	if response.Type == MsgError {
+		return errors.Errorf("expected ACK %v, got ERROR %q", digest, response.Body)
+	}
+	return expectDigest(response, MsgAck, digest)
+}
+
+func (s *conformanceSuite) singleBet() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := s.sendBatch(conn, s.agency(0), s.bets(s.agency(0), 1)); err != nil {
+		return "", err
+	}
+	return "batch of one bet acknowledged", nil
+}
+
+func (s *conformanceSuite) batchAtSizeLimit() (string, error) {
+	agency := s.agency(0)
+	seq := s.nextSeq(agency)
+	bets := []*Bet{}
+	for {
+		next := append(bets, s.bets(agency, 1)...)
+		msg := NewBatchMessage(s.config.Contest, seq, next)
+		if len(msg.Type)+1+len(msg.Body) > MaxPayloadSize {
+			break
+		}
+		bets = next
+	}
+	// Pad the last name so the payload is exactly the maximum
+	msg := NewBatchMessage(s.config.Contest, seq, bets)
+	last := bets[len(bets)-1]
+	last.LastName += strings.Repeat("a", MaxPayloadSize-(len(msg.Type)+1+len(msg.Body)))
+	msg = NewBatchMessage(s.config.Contest, seq, bets)
+
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	response, err := conn.exchange(msg)
+	if err != nil {
+		return "", err
+	}
+	if err := expectAckOf(response, seq); err != nil {
+		return "", err
+	}
+	s.acked(agency, seq, bets)
+	return fmt.Sprintf("payload of %d bytes with %d bets acknowledged", MaxPayloadSize, len(bets)), nil
+}
+
+func (s *conformanceSuite) oversizedFrame() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	frame := make([]byte, headerSize, headerSize+MaxPayloadSize+1)
+	binary.BigEndian.PutUint32(frame, MaxPayloadSize+1)
+	frame = append(frame, MsgBetBatch+"\n0\n"...)
+	frame = append(frame, strings.Repeat("x", MaxPayloadSize+1-(len(frame)-headerSize))...)
+	if err := writeAll(conn.conn, frame); err != nil {
+		return "connection closed while sending the frame", nil
+	}
+
+	response, err := conn.receive()
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return "", errors.New("server kept waiting after an oversized frame")
+	}
+	if err != nil {
+		return "connection closed", nil
+	}
+	if response.Type == MsgAck {
+		return "", errors.New("oversized frame acknowledged")
+	}
+	if response.Type != MsgError {
+		return "", errors.Errorf("expected ERROR or a closed connection, got %s %q", response.Type, response.Body)
+	}
+	return fmt.Sprintf("answered ERROR %q", response.Body), nil
+}
+
+func (s *conformanceSuite) malformedBet() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	agency := s.agency(0)
+	seq := s.nextSeq(agency)
+	body := s.config.Contest + "\n" + strconv.Itoa(seq) + "\nagency:" + agency + "|this is not a bet"
+	response, err := conn.exchange(&Message{Type: MsgBetBatch, Body: []byte(body)})
+	if err != nil {
+		return "", err
+	}
+
+	switch response.Type {
+	case MsgError:
+		s.releaseSeq(agency)
+		return fmt.Sprintf("answered ERROR %q", response.Body), nil
+	case MsgRejected:
+		got, rejections, err := DecodeRejected(response.Body)
+		if err != nil {
+			return "", err
+		}
+		if got != seq || len(rejections) != 1 || rejections[0].Index != 0 {
+			return "", errors.Errorf("expected rejection of bet 0 of batch %d, got %q", seq, response.Body)
+		}
+		// The batch was not stored and has no valid remainder, so the next
+		// batch takes its sequence number
+		s.releaseSeq(agency)
+		return fmt.Sprintf("rejected with reason %q", rejections[0].Reason), nil
+	default:
+		return "", errors.Errorf("expected REJECTED or ERROR, got %s %q", response.Type, response.Body)
+	}
+}
+
+func (s *conformanceSuite) earlyWinnersQuery() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	response, err := conn.exchange(NewSessionMessage(MsgGetWinners, s.agency(0), s.config.Contest))
+	if err != nil {
+		return "", err
+	}
+	if response.Type != MsgError || string(response.Body) != ErrNotAllBatchesReceived {
+		return "", errors.Errorf("expected ERROR %s, got %s %q", ErrNotAllBatchesReceived, response.Type, response.Body)
+	}
+	return "answered " + ErrNotAllBatchesReceived, nil
+}
+
+func (s *conformanceSuite) concurrentAgencies() (string, error) {
+	const batches, betsPerBatch = 20, 10
+
+	errs := make([]error, s.config.Agencies)
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.Agencies; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.uploadAgency(s.agency(i), batches, betsPerBatch)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return "", errors.Wrapf(err, "agency %s", s.agency(i))
+		}
+	}
+	return fmt.Sprintf("%d agencies uploaded %d batches each", s.config.Agencies, batches), nil
+}
+
+// uploadAgency Uploads batches of agency on its own connection and
+// finishes with BATCH_END, checking the digest of the server
+func (s *conformanceSuite) uploadAgency(agency string, batches int, betsPerBatch int) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i := 0; i < batches; i++ {
+		if err := s.sendBatch(conn, agency, s.bets(agency, betsPerBatch)); err != nil {
+			return err
+		}
+	}
+	return s.batchEnd(conn, agency)
+}
+
+func (s *conformanceSuite) batchEndTwice() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	agency := s.agency(0)
+	digest := s.digest(agency, s.lastSeq(agency))
+	response, err := conn.exchange(NewBatchEndMessage(agency, s.config.Contest, digest))
+	if err != nil {
+		return "", err
+	}
+	switch response.Type {
+	case MsgAck:
+		if err := expectDigest(response, MsgAck, digest); err != nil {
+			return "", errors.Wrap(err, "repeated BATCH_END")
+		}
+		return "repeated BATCH_END acknowledged with the same digest", nil
+	case MsgError:
+		return fmt.Sprintf("repeated BATCH_END answered ERROR %q", response.Body), nil
+	default:
+		return "", errors.Errorf("expected ACK or ERROR, got %s %q", response.Type, response.Body)
+	}
+}
+
+func (s *conformanceSuite) digestQuery() (string, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	agency := s.agency(0)
+	last := s.lastSeq(agency)
+	for _, seq := range []int{1, last} {
+		response, err := conn.exchange(NewDigestRequest(agency, s.config.Contest, seq))
+		if err != nil {
+			return "", err
+		}
+		if err := expectDigest(response, MsgDigest, s.digest(agency, seq)); err != nil {
+			return "", errors.Wrapf(err, "batches up to %d", seq)
+		}
+	}
+	return fmt.Sprintf("digests of the first batch and of the %d batches match", last), nil
+}
+
+func (s *conformanceSuite) winnersAfterDraw() (string, error) {
+	deadline := time.Now().Add(s.config.WinnersWait)
+	for {
+		conn, err := s.dial()
+		if err != nil {
+			return "", err
+		}
+		response, err := conn.exchange(NewSessionMessage(MsgGetWinners, s.agency(0), s.config.Contest))
+		draw := ""
+		// The winning number may be sent before the winners
+		if err == nil && response.Type == MsgDraw {
+			if _, ok := DecodeDraw(response.Body); !ok {
+				conn.Close()
+				return "", errors.Errorf("invalid winning number %q in %s", response.Body, MsgDraw)
+			}
+			draw = fmt.Sprintf(" after %s %s", MsgDraw, response.Body)
+			response, err = conn.receive()
+		}
+		winners, parts := 0, 1
+		// Winners split in several frames end with a WINNERS frame
+		for err == nil && response.Type == MsgWinnersPart {
+			winners += len(DecodeWinners(response.Body))
+			parts++
+			response, err = conn.receive()
+		}
+		conn.Close()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case response.Type == MsgWinners:
+			winners += len(DecodeWinners(response.Body))
+			return fmt.Sprintf("%d winners in %d frames%s", winners, parts, draw), nil
+		case response.Type == MsgError && string(response.Body) == ErrNotAllBatchesReceived:
+			if time.Now().After(deadline) {
+				return "", errors.Errorf("draw not available after %v, does the server expect %d agencies?", s.config.WinnersWait, s.config.Agencies)
+			}
+			time.Sleep(100 * time.Millisecond)
+		default:
+			return "", errors.Errorf("expected WINNERS, got %s %q", response.Type, response.Body)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConformanceAgainstTestServer(t *testing.T) {
+	server := newTestServer(t)
+	server.drawNumber = "7574"
+	server.winnersPart = 1
+	// The draw takes place once both agencies of the suite finished, as
+	// a real server would do. Polling stops with the test if they never do
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			_, first := server.stored("1")
+			_, second := server.stored("2")
+			if first && second {
+				server.draw()
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	results := RunConformance(ConformanceConfig{
+		Address:     server.address(),
+		FirstAgency: 1,
+		Agencies:    2,
+		Timeout:     time.Second,
+		WinnersWait: time.Second,
+	})
+	for _, result := range results {
+		if !result.Passed {
+			t.Errorf("case %s failed: %s", result.Name, result.Detail)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContestFile Agency file with the bets of a contest
+type ContestFile struct {
+	Contest string
+	Path    string
+}
+
+// ParseContestFiles Parses a comma separated list of contest=path pairs,
+// as given in the contest.files parameter. An empty value returns no files
+func ParseContestFiles(value string) ([]ContestFile, error) {
+	files := []ContestFile{}
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idx := strings.IndexByte(pair, '=')
+		if idx < 0 {
+			return nil, errors.Errorf("contest file %q must have the form contest=path", pair)
+		}
+		file := ContestFile{
+			Contest: strings.TrimSpace(pair[:idx]),
+			Path:    strings.TrimSpace(pair[idx+1:]),
+		}
+		if err := ValidateContest(file.Contest); err != nil {
+			return nil, err
+		}
+		if file.Path == "" {
+			return nil, errors.Errorf("contest %q has no file", file.Contest)
+		}
+		if seen[file.Contest] {
+			return nil, errors.Errorf("contest %q is listed more than once", file.Contest)
+		}
+		seen[file.Contest] = true
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// SuffixPath Returns path with suffix inserted before its extension, so
+// the files of each contest or agency run in the same process do not
+// overwrite each other. An empty path is returned as is
+func SuffixPath(path string, suffix string) string {
+	if path == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + suffix + ext
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContestsAreUploadedSeparately(t *testing.T) {
+	server := newTestServer(t)
+	for _, contest := range []string{"morning", "night"} {
+		client := NewClient(ClientConfig{
+			ID:              "1",
+			Contest:         contest,
+			ServerAddresses: []string{server.address()},
+			LoopPeriod:      time.Millisecond,
+			BatchMaxAmount:  10,
+			BatchWindow:     2,
+		})
+		// Both uploads start at sequence number 1, which the server only
+		// accepts if it tells the contests apart
+		if err := client.UploadBatches(NewSyntheticBatchSource("1", 25, 10, 1)); err != nil {
+			t.Fatalf("upload of contest %v failed: %v", contest, err)
+		}
+	}
+
+	for _, contest := range []string{"morning", "night"} {
+		if stored, finished := server.storedIn("1", contest); stored != 25 || !finished {
+			t.Fatalf("server stored %d of 25 bets of contest %v (finished: %v)", stored, contest, finished)
+		}
+	}
+	if stored, _ := server.stored("1"); stored != 0 {
+		t.Fatalf("server stored %d bets in the default contest", stored)
+	}
+}
+
+func TestParseContestFiles(t *testing.T) {
+	files, err := ParseContestFiles(" morning=./a.csv, night=./b.csv ,")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	want := []ContestFile{{"morning", "./a.csv"}, {"night", "./b.csv"}}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", files, want)
+	}
+
+	for _, value := range []string{"morning", "=./a.csv", "morning=", "a=./a.csv,a=./b.csv"} {
+		if _, err := ParseContestFiles(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestSuffixPath(t *testing.T) {
+	cases := map[string]string{
+		"./winners-agency-1.csv": "./winners-agency-1-night.csv",
+		"/tmp/out/summary.json":  "/tmp/out/summary-night.json",
+		"winners":                "winners-night",
+		"":                       "",
+	}
+	for path, want := range cases {
+		if got := SuffixPath(path, "night"); got != want {
+			t.Fatalf("SuffixPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// deadLetterHeader Columns of the dead letter file. The row keeps the
+// agency file format after the line number and the rejection reason
+var deadLetterHeader = []string{"line", "reason", "first_name", "last_name", "document", "birthdate", "number"}
+
+// DeadLetter CSV file collecting the rows that could not be uploaded, so
+// the agency can fix and send them again. The file is only created once
+// the first row is added, so a run without rejections leaves no file
+type DeadLetter struct {
+	path   string
+	file   *os.File
+	writer *csv.Writer
+	count  int
+}
+
+// NewDeadLetter Creates a dead letter that writes to path. The file left
+// by a previous run is removed, as its rows no longer apply
+func NewDeadLetter(path string) (*DeadLetter, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "could not remove previous dead letter file %s", path)
+	}
+	return &DeadLetter{path: path}, nil
+}
+
+// Add Appends a rejected row along with its line number and reason
+func (d *DeadLetter) Add(line int, reason string, record []string) error {
+	if d.file == nil {
+		file, err := os.Create(d.path)
+		if err != nil {
+			return errors.Wrapf(err, "could not create dead letter file %s", d.path)
+		}
+		d.file = file
+		d.writer = csv.NewWriter(file)
+		if err := d.writer.Write(deadLetterHeader); err != nil {
+			return err
+		}
+	}
+
+	row := append([]string{strconv.Itoa(line), reason}, record...)
+	if err := d.writer.Write(row); err != nil {
+		return err
+	}
+	d.count++
+	return nil
+}
+
+// Count Returns the amount of rows added
+func (d *DeadLetter) Count() int {
+	return d.count
+}
+
+// Close Flushes and closes the file, if it was created
+func (d *DeadLetter) Close() error {
+	if d.file == nil {
+		return nil
+	}
+	d.writer.Flush()
+	if err := d.writer.Error(); err != nil {
+		d.file.Close()
+		return err
+	}
+	return d.file.Close()
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Digest Order independent digest of a set of bets: their amount and the
+// sum of the hashes of each bet. The hash of a bet is the first 8 bytes
+// of the SHA-256 of its encoding, read as a big endian number
+type Digest struct {
+	Count int
+	Hash  uint64
+}
+
+// BetHash Returns the hash of a bet used by the digests
+func BetHash(bet *Bet) uint64 {
+	sum := sha256.Sum256([]byte(bet.Encode()))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Add Adds bets to the digest
+func (d *Digest) Add(bets []*Bet) {
+	for _, bet := range bets {
+		d.Count++
+		d.Hash += BetHash(bet)
+	}
+}
+
+// Merge Adds the bets of other to the digest
+func (d *Digest) Merge(other Digest) {
+	d.Count += other.Count
+	d.Hash += other.Hash
+}
+
+// String Returns the wire format of the digest, count:hash with the hash
+// as 16 hex digits
+func (d Digest) String() string {
+	return fmt.Sprintf("%d:%016x", d.Count, d.Hash)
+}
+
+// ParseDigest Parses a digest in the format of String
+func ParseDigest(text string) (Digest, error) {
+	idx := strings.IndexByte(text, ':')
+	if idx < 0 {
+		return Digest{}, errors.Errorf("malformed digest %q", text)
+	}
+	count, err := strconv.Atoi(text[:idx])
+	if err != nil || count < 0 {
+		return Digest{}, errors.Errorf("malformed digest count %q", text[:idx])
+	}
+	hash, err := strconv.ParseUint(text[idx+1:], 16, 64)
+	if err != nil {
+		return Digest{}, errors.Errorf("malformed digest hash %q", text[idx+1:])
+	}
+	return Digest{Count: count, Hash: hash}, nil
+}
+
+// uploadDigest Digests of the batches acknowledged by the server, indexed
+// by sequence number
+type uploadDigest struct {
+	batches []Digest
+}
+
+// ack Records the bets of an acknowledged batch
+func (u *uploadDigest) ack(seq int, bets []*Bet) {
+	for len(u.batches) < seq {
+		u.batches = append(u.batches, Digest{})
+	}
+	var digest Digest
+	digest.Add(bets)
+	u.batches[seq-1] = digest
+}
+
+// total Returns the digest of every acknowledged bet
+func (u *uploadDigest) total() Digest {
+	return u.rangeDigest(1, len(u.batches))
+}
+
+// rangeDigest Returns the digest of the batches from first to last,
+// both included
+func (u *uploadDigest) rangeDigest(first int, last int) Digest {
+	var digest Digest
+	for seq := first; seq <= last && seq <= len(u.batches); seq++ {
+		digest.Merge(u.batches[seq-1])
+	}
+	return digest
+}
+
+// DigestMismatchError The digest computed by the server differs from the
+// one of the bets acknowledged. FirstBatch is the first batch whose bets
+// differ, or 0 if it could not be found
+type DigestMismatchError struct {
+	Local      Digest
+	Server     Digest
+	FirstBatch int
+}
+
+func (e *DigestMismatchError) Error() string {
+	msg := fmt.Sprintf("server digest %v does not match the bets acknowledged %v", e.Server, e.Local)
+	if e.FirstBatch > 0 {
+		msg += fmt.Sprintf(", first divergent batch: %d", e.FirstBatch)
+	}
+	return msg
+}
+
+// verifyDigest Compares the digest of the ACK to BATCH_END with the one
+// of the bets acknowledged. An ACK without digest fails unless
+// RequireDigest is disabled. On a mismatch the first divergent batch is
+// searched asking the server for the digest of a prefix of the batches,
+// halving the candidates on every request
+func (c *Client) verifyDigest(response *Message) error {
+	local := c.digest.total()
+	if len(response.Body) == 0 {
+		err := errors.New("server did not return its digest")
+		if !c.config.RequireDigest {
+			log.Warningf("action: digest | result: skipped | client_id: %v | local: %v | error: %v", c.config.ID, local, err)
+			return nil
+		}
+		log.Errorf("action: digest | result: fail | client_id: %v | local: %v | error: %v", c.config.ID, local, err)
+		return err
+	}
+	server, err := ParseDigest(string(response.Body))
+	if err != nil {
+		return err
+	}
+	if server == local {
+		log.Infof("action: digest | result: success | client_id: %v | digest: %v", c.config.ID, local)
+		return nil
+	}
+
+	mismatch := &DigestMismatchError{Local: local, Server: server}
+	first, err := c.firstDivergentBatch()
+	if err != nil {
+		log.Warningf("action: digest | result: fail | client_id: %v | error: could not find the divergent batch: %v", c.config.ID, err)
+	}
+	mismatch.FirstBatch = first
+	log.Criticalf("action: digest | result: fail | client_id: %v | local: %v | server: %v | first_divergent_batch: %v",
+		c.config.ID,
+		local,
+		server,
+		first,
+	)
+	return mismatch
+}
+
+// firstDivergentBatch Returns the first batch whose digest differs from
+// the one computed by the server
+func (c *Client) firstDivergentBatch() (int, error) {
+	if len(c.digest.batches) == 0 {
+		return 0, errors.New("no batch was acknowledged")
+	}
+	// Invariant: the batches before low match and the ones up to high
+	// do not. A server that stored batches never acknowledged points to
+	// the last one
+	low, high := 1, len(c.digest.batches)
+	for low < high {
+		mid := (low + high) / 2
+		server, err := c.serverDigest(mid)
+		if err != nil {
+			return 0, err
+		}
+		if server == c.digest.rangeDigest(1, mid) {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// serverDigest Requests the digest of the batches up to last computed by
+// the server
+func (c *Client) serverDigest(last int) (Digest, error) {
+	msg := NewDigestRequest(c.config.ID, c.config.Contest, last)
+	response, err := c.exchange(msg)
+	if err != nil {
+		return Digest{}, err
+	}
+	if response.Type != MsgDigest {
+		return Digest{}, errors.Errorf("%s not supported, got %s %q", msg.Type, response.Type, response.Body)
+	}
+	return ParseDigest(string(response.Body))
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestDigestIsOrderIndependent(t *testing.T) {
+	bets, _ := NewSyntheticBatchSource("1", 3, 3, 1).NextBatch()
+	var forward, backward Digest
+	forward.Add(bets)
+	backward.Add([]*Bet{bets[2], bets[0], bets[1]})
+	if forward != backward || forward.Count != 3 {
+		t.Fatalf("digests %v and %v of the same bets differ", forward, backward)
+	}
+
+	parsed, err := ParseDigest(forward.String())
+	if err != nil || parsed != forward {
+		t.Fatalf("parsed %v (%v), want %v", parsed, err, forward)
+	}
+}
+
+func TestDigestMismatchPointsToFirstDivergentBatch(t *testing.T) {
+	for _, lost := range []int{1, 7, 10} {
+		server := newTestServer(t)
+		server.loseBetOf = lost
+		client := NewClient(ClientConfig{
+			ID:              "1",
+			ServerAddresses: []string{server.address()},
+			LoopPeriod:      time.Millisecond,
+			BatchMaxAmount:  10,
+			BatchWindow:     4,
+		})
+
+		err := client.UploadBatches(NewSyntheticBatchSource("1", 100, 10, 1))
+		mismatch, ok := errors.Cause(err).(*DigestMismatchError)
+		if !ok {
+			t.Fatalf("expected a digest mismatch, got %v", err)
+		}
+		if mismatch.FirstBatch != lost || mismatch.Local.Count != 100 || mismatch.Server.Count != 99 {
+			t.Fatalf("got %v, want first divergent batch %d", mismatch, lost)
+		}
+	}
+}
+
+func TestMissingDigestFailsUnlessOptional(t *testing.T) {
+	for _, required := range []bool{true, false} {
+		server := newTestServer(t)
+		server.noDigest = true
+		client := NewClient(ClientConfig{
+			ID:              "1",
+			ServerAddresses: []string{server.address()},
+			BatchMaxAmount:  10,
+			RequireDigest:   required,
+		})
+
+		err := client.UploadBatches(NewSyntheticBatchSource("1", 20, 10, 1))
+		if required && err == nil {
+			t.Fatalf("upload without server digest succeeded")
+		}
+		if !required && err != nil {
+			t.Fatalf("upload with optional digest failed: %v", err)
+		}
+	}
+}
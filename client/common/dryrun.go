@@ -0,0 +1,110 @@
+package common
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// DryRunReport What the client would send for an agency file. Batch sizes
+// are measured over the encoded frames, header included
+type DryRunReport struct {
+	File        string
+	Batches     int
+	Bets        int
+	MinBytes    int
+	MaxBytes    int
+	TotalBytes  int
+	MinBets     int
+	MaxBets     int
+	Rejected    []Rejection
+	FirstFrames [][]byte
+}
+
+// DryRun Runs the ingestion and batching pipeline over the agency file
+// located at path without connecting to the server. The first frames
+// encoded frames are kept in the report
+func (c *Client) DryRun(path string, frames int) (*DryRunReport, error) {
+	batches, err := NewBatchReader(c.config.ID, path, c.config.BetsCharset, c.config.BatchMaxAmount)
+	if err != nil {
+		return nil, err
+	}
+	defer batches.Close()
+	batches.SetValidator(c.validator)
+	defer c.validator.LogSummary(c.config.ID)
+	if err := c.detectDuplicates(batches, path); err != nil {
+		return nil, err
+	}
+
+	report := &DryRunReport{File: path}
+	for seq := 1; ; seq++ {
+		batch, err := batches.NextBatch()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		frame, err := NewBatchMessage(c.config.Contest, seq, batch).Encode()
+		if err != nil {
+			return nil, err
+		}
+		report.addBatch(len(batch), frame)
+		if len(report.FirstFrames) < frames {
+			report.FirstFrames = append(report.FirstFrames, frame)
+		}
+	}
+	report.Rejected = batches.Rejected()
+	return report, nil
+}
+
+func (r *DryRunReport) addBatch(bets int, frame []byte) {
+	if r.Batches == 0 || len(frame) < r.MinBytes {
+		r.MinBytes = len(frame)
+	}
+	if len(frame) > r.MaxBytes {
+		r.MaxBytes = len(frame)
+	}
+	if r.Batches == 0 || bets < r.MinBets {
+		r.MinBets = bets
+	}
+	if bets > r.MaxBets {
+		r.MaxBets = bets
+	}
+	r.Batches++
+	r.Bets += bets
+	r.TotalBytes += len(frame)
+}
+
+// Write Writes the report as text: batch statistics, rejected rows and a
+// hex and text dump of the first frames
+func (r *DryRunReport) Write(w io.Writer) error {
+	var avgBytes, avgBets float64
+	if r.Batches > 0 {
+		avgBytes = float64(r.TotalBytes) / float64(r.Batches)
+		avgBets = float64(r.Bets) / float64(r.Batches)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "file\t%s\n", r.File)
+	fmt.Fprintf(tw, "batches\t%d\n", r.Batches)
+	fmt.Fprintf(tw, "bets\t%d\n", r.Bets)
+	fmt.Fprintf(tw, "rejected\t%d\n", len(r.Rejected))
+	fmt.Fprintf(tw, "batch bytes\tmin %d\tavg %.1f\tmax %d\n", r.MinBytes, avgBytes, r.MaxBytes)
+	fmt.Fprintf(tw, "batch bets\tmin %d\tavg %.1f\tmax %d\n", r.MinBets, avgBets, r.MaxBets)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, rejection := range r.Rejected {
+		fmt.Fprintf(w, "rejected line %d: %s: %s\n", rejection.Line, rejection.Reason, strings.Join(rejection.Record, ","))
+	}
+	for i, frame := range r.FirstFrames {
+		fmt.Fprintf(w, "\nframe %d (%d bytes)\n%s", i+1, len(frame), hex.Dump(frame))
+		fmt.Fprintf(w, "text: %q\n", frame[headerSize:])
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// appendRows Appends raw rows to the agency file located at path
+func appendRows(t *testing.T, path string, rows string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(rows); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+}
+
+func TestDryRunReportsBatchesWithoutConnecting(t *testing.T) {
+	path := writeAgencyFile(t, sequentialBets(25))
+	appendRows(t, path, "Nombre,Apellido,30000026,1990-01-01\n")
+	// No server is configured, so any connection attempt fails
+	client := NewClient(ClientConfig{ID: "1", BatchMaxAmount: 10})
+
+	report, err := client.DryRun(path, 2)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if report.Batches != 3 || report.Bets != 25 || report.MinBets != 5 || report.MaxBets != 10 {
+		t.Fatalf("unexpected report %+v", *report)
+	}
+	if len(report.Rejected) != 1 || report.Rejected[0].Line != 26 {
+		t.Fatalf("unexpected rejections %+v", report.Rejected)
+	}
+	if len(report.FirstFrames) != 2 || report.MinBytes > report.MaxBytes || report.TotalBytes < 2*report.MaxBytes {
+		t.Fatalf("unexpected frames: %d kept, %d to %d bytes, %d in total",
+			len(report.FirstFrames), report.MinBytes, report.MaxBytes, report.TotalBytes)
+	}
+	for i, frame := range report.FirstFrames {
+		msg, err := DecodeMessage(frame[headerSize:])
+		if err != nil || msg.Type != MsgBetBatch {
+			t.Fatalf("frame %d is not a batch: %v", i+1, err)
+		}
+		if _, seq, bets, err := DecodeBatchMessage(msg.Body); err != nil || seq != i+1 || len(bets) != 10 {
+			t.Fatalf("frame %d holds batch %d of %d bets: %v", i+1, seq, len(bets), err)
+		}
+	}
+}
+
+func TestDryRunReportIsWritten(t *testing.T) {
+	client := NewClient(ClientConfig{ID: "1", BatchMaxAmount: 10})
+	report, err := client.DryRun(writeAgencyFile(t, sequentialBets(3)), 1)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := report.Write(&out); err != nil {
+		t.Fatalf("could not write report: %v", err)
+	}
+	fields := strings.Join(strings.Fields(out.String()), " ")
+	for _, want := range []string{"batches 1", "bets 3", "rejected 0", "frame 1 (", "text: \"BET_BATCH"} {
+		if !strings.Contains(fields, want) {
+			t.Fatalf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"hash/fnv"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Policies applied to a bet repeated in the agency file
+const (
+	// DuplicateDrop Skips the repeated bet, reporting it as a rejection
+	DuplicateDrop = "drop"
+	// DuplicateWarn Logs the repeated bet and uploads it anyway
+	DuplicateWarn = "warn"
+	// DuplicateFail Aborts the upload
+	DuplicateFail = "fail"
+)
+
+// filterHashes Amount of bits of the filter set for every bet
+const filterHashes = 4
+
+// DuplicateConfig Detection of bets repeated in the agency file, that is
+// bets with the same document and number
+type DuplicateConfig struct {
+	Policy string
+	// ExactMaxSize Files up to this size in bytes are checked keeping
+	// every bet in memory. Larger files are first scanned with a filter
+	// of FilterBits bits, and only the bets the filter flags as possibly
+	// repeated are kept in memory during the upload
+	ExactMaxSize int64
+	FilterBits   uint64
+}
+
+// DuplicateError A bet repeated in the agency file
+type DuplicateError struct {
+	Line      int
+	FirstLine int
+	Document  string
+	Number    int
+}
+
+func (e *DuplicateError) Error() string {
+	return "duplicate of line " + strconv.Itoa(e.FirstLine)
+}
+
+// duplicateDetector Finds the bets already read from the agency file.
+// Detection is exact: the filter only decides which bets are tracked
+type duplicateDetector struct {
+	policy string
+	// candidates Bets that may be repeated. nil tracks every bet
+	candidates map[string]bool
+	// seen Line where every tracked bet was first read
+	seen  map[string]int
+	found int
+}
+
+// newDuplicateDetector Creates the detector of the agency file located
+// at path. If the file is larger than ExactMaxSize it is scanned once to
+// find the bets that may be repeated. Bets that break a rule of
+// validator, if any, are never uploaded, so the scan skips them
+func newDuplicateDetector(config DuplicateConfig, agency string, path string, charset string, validator *Validator) (*duplicateDetector, error) {
+	detector := &duplicateDetector{policy: config.Policy, seen: map[string]int{}}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open bets file %s", path)
+	}
+	if info.Size() <= config.ExactMaxSize || config.FilterBits == 0 {
+		return detector, nil
+	}
+
+	reader, err := NewBatchReader(agency, path, charset, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if validator != nil {
+		reader.SetValidator(validator)
+	}
+
+	filter := newBloomFilter(config.FilterBits)
+	detector.candidates = map[string]bool{}
+	for {
+		bet, err := reader.ReadBet()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*RowError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		key := duplicateKey(bet)
+		if filter.add(key) {
+			detector.candidates[key] = true
+		}
+	}
+	log.Debugf("action: detectar_duplicados | result: in_progress | client_id: %v | file: %v | candidates: %v",
+		agency,
+		path,
+		len(detector.candidates),
+	)
+	return detector, nil
+}
+
+// check Returns a DuplicateError if a bet with the same document and
+// number was already checked, nil otherwise
+func (d *duplicateDetector) check(bet *Bet) *DuplicateError {
+	key := duplicateKey(bet)
+	if d.candidates != nil && !d.candidates[key] {
+		return nil
+	}
+	if first, ok := d.seen[key]; ok {
+		d.found++
+		return &DuplicateError{Line: bet.Line, FirstLine: first, Document: bet.Document, Number: bet.Number}
+	}
+	d.seen[key] = bet.Line
+	return nil
+}
+
+// precheck Reads every bet of reader looking for a repeated one, so the
+// fail policy aborts the upload before anything is sent. The bets seen
+// are forgotten afterwards, as the upload checks them again
+func (d *duplicateDetector) precheck(reader *BatchReader) *DuplicateError {
+	defer func() {
+		d.seen = map[string]int{}
+		d.found = 0
+	}()
+	for {
+		bet, err := reader.ReadBet()
+		// Invalid rows and read errors are reported by the upload itself
+		if _, ok := err.(*RowError); ok {
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+		if dupErr := d.check(bet); dupErr != nil {
+			return dupErr
+		}
+	}
+}
+
+func duplicateKey(bet *Bet) string {
+	return bet.Document + "|" + strconv.Itoa(bet.Number)
+}
+
+// bloomFilter Set of bounded size that may report elements that were
+// never added, but never misses an element that was
+type bloomFilter struct {
+	bits []uint64
+	size uint64
+}
+
+func newBloomFilter(size uint64) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, (size+63)/64), size: size}
+}
+
+// add Adds key to the filter and returns true if it may have been added
+// before
+func (f *bloomFilter) add(key string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	h1 := hash.Sum64()
+	// The second hash is derived from the first one, as in double hashing
+	h2 := h1>>33 | h1<<31 | 1
+
+	present := true
+	for i := uint64(0); i < filterHashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			present = false
+			f.bits[word] |= mask
+		}
+	}
+	return present
+}
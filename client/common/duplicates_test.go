@@ -0,0 +1,134 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// readWithDuplicates Reads every bet of the file applying config and
+// returns the lines of the bets read
+func readWithDuplicates(t *testing.T, path string, config DuplicateConfig) ([]int, *BatchReader, error) {
+	reader, err := NewBatchReader("1", path, "utf-8", 10)
+	if err != nil {
+		t.Fatalf("could not open agency file: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+	detector, err := newDuplicateDetector(config, "1", path, "utf-8", nil)
+	if err != nil {
+		t.Fatalf("could not create detector: %v", err)
+	}
+	reader.setDuplicateDetector(detector)
+
+	lines := []int{}
+	for {
+		batch, err := reader.NextBatch()
+		if err == io.EOF {
+			return lines, reader, nil
+		}
+		if err != nil {
+			return lines, reader, err
+		}
+		for _, bet := range batch {
+			lines = append(lines, bet.Line)
+		}
+	}
+}
+
+func TestDuplicatesAreDropped(t *testing.T) {
+	path := writeAgencyFile(t, [][2]int{{30904465, 7}, {21689196, 7}, {30904465, 7}, {30904465, 8}, {21689196, 7}})
+
+	configs := map[string]DuplicateConfig{
+		"exact": {Policy: DuplicateDrop, ExactMaxSize: 1 << 20, FilterBits: 1 << 10},
+		// A tiny filter flags almost every bet, which must not change
+		// the outcome
+		"filter": {Policy: DuplicateDrop, ExactMaxSize: 0, FilterBits: 8},
+	}
+	for name, config := range configs {
+		lines, reader, err := readWithDuplicates(t, path, config)
+		if err != nil {
+			t.Fatalf("%s: read failed: %v", name, err)
+		}
+		if want := "[1 2 4]"; fmt.Sprint(lines) != want {
+			t.Fatalf("%s: read lines %v, want %v", name, lines, want)
+		}
+		if reader.Duplicates() != 2 || len(reader.Rejected()) != 2 {
+			t.Fatalf("%s: %d duplicates and %d rejections, want 2", name, reader.Duplicates(), len(reader.Rejected()))
+		}
+		if reason := reader.Rejected()[1].Reason; reason != "duplicate of line 2" {
+			t.Fatalf("%s: rejection reason %q", name, reason)
+		}
+	}
+}
+
+func TestDuplicatesWarnAndFail(t *testing.T) {
+	path := writeAgencyFile(t, [][2]int{{30904465, 7}, {21689196, 7}, {30904465, 7}})
+
+	lines, reader, err := readWithDuplicates(t, path, DuplicateConfig{Policy: DuplicateWarn})
+	if err != nil || len(lines) != 3 || reader.Duplicates() != 1 {
+		t.Fatalf("warn: read %v (%v) with %d duplicates", lines, err, reader.Duplicates())
+	}
+
+	_, _, err = readWithDuplicates(t, path, DuplicateConfig{Policy: DuplicateFail})
+	if _, ok := errors.Cause(err).(*DuplicateError); !ok {
+		t.Fatalf("fail: expected a duplicate error, got %v", err)
+	}
+}
+
+func TestDuplicateFailSendsNothing(t *testing.T) {
+	// The repeated bet is read long after the first batch
+	pairs := sequentialBets(40)
+	pairs = append(pairs, pairs[0])
+	path := writeAgencyFile(t, pairs)
+	configs := map[string]DuplicateConfig{
+		"exact":  {Policy: DuplicateFail, ExactMaxSize: 1 << 20},
+		"filter": {Policy: DuplicateFail, FilterBits: 1 << 12},
+	}
+	for name, duplicates := range configs {
+		server := newTestServer(t)
+		client := NewClient(ClientConfig{
+			ID:              "1",
+			ServerAddresses: []string{server.address()},
+			BatchMaxAmount:  10,
+			Duplicates:      duplicates,
+		})
+
+		err := client.SendBets(path)
+		if dupErr, ok := errors.Cause(err).(*DuplicateError); !ok || dupErr.Line != 41 || dupErr.FirstLine != 1 {
+			t.Fatalf("%s: expected a duplicate of line 1 at line 41, got %v", name, err)
+		}
+		if stored, finished := server.stored("1"); stored != 0 || finished {
+			t.Fatalf("%s: server stored %d bets (finished: %v) despite the fail policy", name, stored, finished)
+		}
+	}
+}
+
+func TestFilterScanSkipsInvalidBets(t *testing.T) {
+	// The repeated bet has a document too short to be uploaded
+	path := writeAgencyFile(t, [][2]int{{123, 7}, {30904465, 7}, {123, 7}})
+	config := DuplicateConfig{Policy: DuplicateDrop, FilterBits: 1 << 12}
+	validation := ValidationConfig{DocumentEnabled: true, DocumentMinDigits: 7, DocumentMaxDigits: 8}
+
+	detector, err := newDuplicateDetector(config, "1", path, "utf-8", NewValidator(validation, time.Now()))
+	if err != nil {
+		t.Fatalf("could not create detector: %v", err)
+	}
+	if len(detector.candidates) != 0 {
+		t.Fatalf("tracking %d candidates, want none as the repeated bet is invalid", len(detector.candidates))
+	}
+}
+
+func TestBloomFilterHasNoFalseNegatives(t *testing.T) {
+	filter := newBloomFilter(1 << 12)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprint(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !filter.add(fmt.Sprint(i)) {
+			t.Fatalf("key %d added before was not found", i)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policies used to pick the server to connect to
+const (
+	// PolicyFailover Always prefers the first healthy server of the list
+	PolicyFailover = "failover"
+	// PolicyRoundRobin Starts every connection on the server after the
+	// one used by the previous connection
+	PolicyRoundRobin = "round_robin"
+)
+
+// EndpointPool The central server addresses a client can connect to. A
+// server that fails is ejected for a while, so the next connections try
+// the other ones first
+type EndpointPool struct {
+	addresses []string
+	policy    string
+	ejectFor  time.Duration
+	// ejectedUntil Time at which each server is considered healthy again
+	ejectedUntil []time.Time
+	next         int
+	now          func() time.Time
+}
+
+// NewEndpointPool Creates a pool with the given addresses, tried in the
+// order defined by policy. Failing servers are ejected for ejectFor. Any
+// policy other than round robin is considered failover
+func NewEndpointPool(addresses []string, policy string, ejectFor time.Duration) *EndpointPool {
+	return &EndpointPool{
+		addresses:    addresses,
+		policy:       policy,
+		ejectFor:     ejectFor,
+		ejectedUntil: make([]time.Time, len(addresses)),
+		now:          time.Now,
+	}
+}
+
+// ParseAddresses Splits a comma separated list of addresses
+func ParseAddresses(list string) []string {
+	addresses := []string{}
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// Candidates Returns every address in the order they should be tried for
+// the next connection. Healthy servers come first; ejected ones are left
+// at the end, sooner readmission first, so a connection is attempted even
+// if every server failed recently
+func (p *EndpointPool) Candidates() []string {
+	if len(p.addresses) == 0 {
+		return nil
+	}
+	start := 0
+	if p.policy == PolicyRoundRobin {
+		start = p.next
+		p.next = (p.next + 1) % len(p.addresses)
+	}
+
+	now := p.now()
+	order := make([]int, 0, len(p.addresses))
+	for i := range p.addresses {
+		order = append(order, (start+i)%len(p.addresses))
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := p.ejectedUntil[order[i]], p.ejectedUntil[order[j]]
+		aHealthy, bHealthy := !a.After(now), !b.After(now)
+		if aHealthy || bHealthy {
+			return aHealthy && !bHealthy
+		}
+		return a.Before(b)
+	})
+
+	candidates := make([]string, len(order))
+	for i, index := range order {
+		candidates[i] = p.addresses[index]
+	}
+	return candidates
+}
+
+// MarkFailure Ejects address for the configured period
+func (p *EndpointPool) MarkFailure(address string) {
+	for i, candidate := range p.addresses {
+		if candidate == address {
+			p.ejectedUntil[i] = p.now().Add(p.ejectFor)
+		}
+	}
+}
+
+// MarkSuccess Considers address healthy again
+func (p *EndpointPool) MarkSuccess(address string) {
+	for i, candidate := range p.addresses {
+		if candidate == address {
+			p.ejectedUntil[i] = time.Time{}
+		}
+	}
+}
+
+// Len Returns the amount of servers in the pool
+func (p *EndpointPool) Len() int {
+	return len(p.addresses)
+}
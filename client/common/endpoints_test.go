@@ -0,0 +1,95 @@
+package common
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestPool Pool of servers a, b and c whose clock is moved by the
+// returned function
+func newTestPool(policy string) (*EndpointPool, func(time.Duration)) {
+	pool := NewEndpointPool([]string{"a", "b", "c"}, policy, 10*time.Second)
+	now := time.Unix(0, 0)
+	pool.now = func() time.Time { return now }
+	return pool, func(d time.Duration) { now = now.Add(d) }
+}
+
+func expectCandidates(t *testing.T, pool *EndpointPool, want ...string) {
+	t.Helper()
+	if got := pool.Candidates(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got candidates %v, want %v", got, want)
+	}
+}
+
+func TestFailoverPrefersFirstHealthyServer(t *testing.T) {
+	pool, advance := newTestPool(PolicyFailover)
+	expectCandidates(t, pool, "a", "b", "c")
+	expectCandidates(t, pool, "a", "b", "c")
+
+	pool.MarkFailure("a")
+	expectCandidates(t, pool, "b", "c", "a")
+	advance(5 * time.Second)
+	pool.MarkFailure("b")
+	expectCandidates(t, pool, "c", "a", "b")
+
+	// a is readmitted once its ejection elapses
+	advance(5 * time.Second)
+	expectCandidates(t, pool, "a", "c", "b")
+	pool.MarkSuccess("b")
+	expectCandidates(t, pool, "a", "b", "c")
+}
+
+func TestRoundRobinRotatesServers(t *testing.T) {
+	pool, _ := newTestPool(PolicyRoundRobin)
+	expectCandidates(t, pool, "a", "b", "c")
+	expectCandidates(t, pool, "b", "c", "a")
+	expectCandidates(t, pool, "c", "a", "b")
+
+	pool.MarkFailure("b")
+	expectCandidates(t, pool, "a", "c", "b")
+	expectCandidates(t, pool, "c", "a", "b")
+}
+
+func TestEjectedServersAreTriedLast(t *testing.T) {
+	pool, advance := newTestPool(PolicyFailover)
+	pool.MarkFailure("b")
+	advance(time.Second)
+	pool.MarkFailure("a")
+	advance(time.Second)
+	pool.MarkFailure("c")
+	// Every server is ejected, so they are tried sooner readmission first
+	expectCandidates(t, pool, "b", "a", "c")
+}
+
+func TestUploadFailsOverToNextServer(t *testing.T) {
+	// Nothing listens on the first address anymore
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{down, server.address()},
+		ServerEjectFor:  time.Minute,
+		ResponseTimeout: time.Second,
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     1,
+		BatchRetries:    3,
+	})
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 20, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, finished := server.stored("1"); stored != 20 || !finished {
+		t.Fatalf("server stored %d of 20 bets (finished: %v)", stored, finished)
+	}
+	if candidates := client.endpoints.Candidates(); candidates[0] != server.address() {
+		t.Fatalf("unreachable server not ejected: %v", candidates)
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Delimiters of the bet text encoding. Any of them, the escape character
+// and line breaks are escaped with a backslash when they appear inside a
+// value, so values can hold arbitrary bytes
+const (
+	escapeChar     = '\\'
+	fieldSeparator = '|'
+	keySeparator   = ':'
+	listSeparator  = ';'
+)
+
+var valueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`|`, `\|`,
+	`:`, `\:`,
+	`;`, `\;`,
+	"\n", `\n`,
+	"\r", `\r`,
+)
+
+// escapeValue Escapes the delimiters contained in value
+func escapeValue(value string) string {
+	return valueEscaper.Replace(value)
+}
+
+// unescapeValue Reverts escapeValue. An error is returned for unknown
+// escape sequences and for a trailing escape character
+func unescapeValue(escaped string) (string, error) {
+	if strings.IndexByte(escaped, escapeChar) < 0 {
+		return escaped, nil
+	}
+
+	var b strings.Builder
+	b.Grow(len(escaped))
+	for i := 0; i < len(escaped); i++ {
+		c := escaped[i]
+		if c != escapeChar {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(escaped) {
+			return "", errors.New("trailing escape character")
+		}
+		switch escaped[i] {
+		case escapeChar, fieldSeparator, keySeparator, listSeparator:
+			b.WriteByte(escaped[i])
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", errors.Errorf("unknown escape sequence \\%c", escaped[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// splitEscaped Splits s around the occurrences of sep that are not
+// escaped. The parts are returned still escaped
+func splitEscaped(s string, sep byte) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case escapeChar:
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FaultConfig Faults injected on every connection with the server, used
+// to check that the client survives hostile networks. The zero value
+// injects no fault
+type FaultConfig struct {
+	// ShortWrites and ShortReads Transfer a random prefix of the buffer
+	// on every call
+	ShortWrites bool
+	ShortReads  bool
+	// Latency Delay added before every read and write
+	Latency time.Duration
+	// DropAfter Closes the connection once this amount of bytes has been
+	// transferred in either direction. 0 disables it
+	DropAfter int64
+	// CorruptRate Probability of flipping a bit of every byte read
+	CorruptRate float64
+	// StallAfter and StallFor Block the read that goes past StallAfter
+	// bytes read during StallFor. 0 disables it
+	StallAfter int64
+	StallFor   time.Duration
+	// Seed Seed of the random faults. 0 uses the current time
+	Seed int64
+}
+
+// Enabled Returns true if any fault is configured
+func (c FaultConfig) Enabled() bool {
+	return c.ShortWrites || c.ShortReads || c.Latency > 0 || c.DropAfter > 0 ||
+		c.CorruptRate > 0 || (c.StallAfter > 0 && c.StallFor > 0)
+}
+
+// errInjectedDrop Returned once a faulty connection drops
+var errInjectedDrop = errors.New("connection dropped by fault injection")
+
+// faultyConn Connection that injects the faults of its config
+type faultyConn struct {
+	net.Conn
+	config      FaultConfig
+	rnd         *rand.Rand
+	transferred int64
+	read        int64
+	stalled     bool
+	dropped     bool
+}
+
+// NewFaultyConn Wraps conn so the faults of config are injected on it.
+// rnd is not safe for concurrent use, so it must not be shared between
+// goroutines
+func NewFaultyConn(conn net.Conn, config FaultConfig, rnd *rand.Rand) net.Conn {
+	return &faultyConn{Conn: conn, config: config, rnd: rnd}
+}
+
+// newFaultRand Creates the random source used to inject the faults
+func newFaultRand(config FaultConfig) *rand.Rand {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// limit Returns how many of the n bytes requested can be transferred
+// before the connection drops, dropping it if there are none left
+func (c *faultyConn) limit(n int) (int, error) {
+	if c.dropped {
+		return 0, errInjectedDrop
+	}
+	if c.config.DropAfter <= 0 {
+		return n, nil
+	}
+	left := c.config.DropAfter - c.transferred
+	if left <= 0 {
+		c.dropped = true
+		c.Conn.Close()
+		return 0, errInjectedDrop
+	}
+	if int64(n) > left {
+		n = int(left)
+	}
+	return n, nil
+}
+
+// shorten Returns a random amount of bytes between 1 and n
+func (c *faultyConn) shorten(n int) int {
+	if n <= 1 {
+		return n
+	}
+	return 1 + c.rnd.Intn(n)
+}
+
+func (c *faultyConn) Write(b []byte) (int, error) {
+	if c.config.Latency > 0 {
+		time.Sleep(c.config.Latency)
+	}
+	n, err := c.limit(len(b))
+	if err != nil {
+		return 0, err
+	}
+	if c.config.ShortWrites {
+		n = c.shorten(n)
+	}
+	written, err := c.Conn.Write(b[:n])
+	c.transferred += int64(written)
+	return written, err
+}
+
+func (c *faultyConn) Read(b []byte) (int, error) {
+	if c.config.Latency > 0 {
+		time.Sleep(c.config.Latency)
+	}
+	if c.config.StallAfter > 0 && !c.stalled && c.read >= c.config.StallAfter {
+		c.stalled = true
+		time.Sleep(c.config.StallFor)
+	}
+	n, err := c.limit(len(b))
+	if err != nil {
+		return 0, err
+	}
+	if c.config.ShortReads {
+		n = c.shorten(n)
+	}
+
+	read, err := c.Conn.Read(b[:n])
+	for i := 0; i < read; i++ {
+		if c.config.CorruptRate > 0 && c.rnd.Float64() < c.config.CorruptRate {
+			b[i] ^= 1 << uint(c.rnd.Intn(8))
+		}
+	}
+	c.transferred += int64(read)
+	c.read += int64(read)
+	return read, err
+}
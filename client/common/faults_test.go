@@ -0,0 +1,110 @@
+package common
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// uploadWithFaults Uploads bets synthetic bets through a client that
+// injects faults and checks that the server stored each of them once
+func uploadWithFaults(t *testing.T, faults FaultConfig, bets int) *Client {
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     4,
+		BatchRetries:    20,
+		ResponseTimeout: 200 * time.Millisecond,
+		Faults:          faults,
+	})
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", bets, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	stored, finished := server.stored("1")
+	if stored != bets || !finished {
+		t.Fatalf("server stored %d of %d bets (finished: %v)", stored, bets, finished)
+	}
+	return client
+}
+
+func TestUploadWithShortReadsAndWrites(t *testing.T) {
+	uploadWithFaults(t, FaultConfig{ShortReads: true, ShortWrites: true, Seed: 1}, 300)
+}
+
+func TestUploadWithLatency(t *testing.T) {
+	uploadWithFaults(t, FaultConfig{Latency: time.Millisecond, Seed: 1}, 100)
+}
+
+func TestUploadResumesAfterDrops(t *testing.T) {
+	client := uploadWithFaults(t, FaultConfig{DropAfter: 4000, ShortWrites: true, Seed: 1}, 500)
+	if client.stats.reconnections == 0 {
+		t.Fatalf("expected the upload to reconnect")
+	}
+}
+
+func TestUploadSurvivesCorruption(t *testing.T) {
+	client := uploadWithFaults(t, FaultConfig{CorruptRate: 0.01, Seed: 1}, 500)
+	if client.stats.retries == 0 {
+		t.Fatalf("expected corrupted responses to be retried")
+	}
+}
+
+func TestUploadSurvivesStalledReads(t *testing.T) {
+	client := uploadWithFaults(t, FaultConfig{StallAfter: 20, StallFor: 300 * time.Millisecond, Seed: 1}, 200)
+	if client.stats.retries == 0 {
+		t.Fatalf("expected the stalled read to time out and be retried")
+	}
+}
+
+func TestFaultyConnDropsAfterLimit(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	conn := NewFaultyConn(client, FaultConfig{DropAfter: 10}, rand.New(rand.NewSource(1)))
+	if n, err := conn.Write(make([]byte, 6)); n != 6 || err != nil {
+		t.Fatalf("first write: got %d, %v", n, err)
+	}
+	if n, err := conn.Write(make([]byte, 6)); n != 4 || err != nil {
+		t.Fatalf("write past the limit: got %d, %v", n, err)
+	}
+	if _, err := conn.Write(make([]byte, 1)); err != errInjectedDrop {
+		t.Fatalf("write after the limit: got %v, want %v", err, errInjectedDrop)
+	}
+}
+
+func TestFaultyConnShortWrites(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(server)
+		received <- data
+	}()
+
+	conn := NewFaultyConn(client, FaultConfig{ShortWrites: true}, rand.New(rand.NewSource(1)))
+	payload := []byte("a message long enough to be split in several writes")
+	short := false
+	for data := payload; len(data) > 0; {
+		n, err := conn.Write(data)
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		short = short || n < len(data)
+		data = data[n:]
+	}
+	conn.Close()
+
+	if !short {
+		t.Fatalf("expected at least one short write")
+	}
+	if got := <-received; string(got) != string(payload) {
+		t.Fatalf("received %q, want %q", got, payload)
+	}
+}
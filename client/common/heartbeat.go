@@ -0,0 +1,63 @@
+package common
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HeartbeatConfig Liveness checks of the connections with the server
+type HeartbeatConfig struct {
+	// Interval Silence after which a PING is sent while waiting for a
+	// response. 0 disables the heartbeats, as the server must answer
+	// PING with PONG
+	Interval time.Duration
+	// Misses Unanswered PINGs after which the connection is dead
+	Misses int
+	// KeepAlive Period of the TCP keepalive probes. 0 uses the default
+	// of the OS and a negative value disables them
+	KeepAlive time.Duration
+}
+
+// awaitResponse Waits until the server starts sending a frame. While the
+// connection is silent a PING is sent every heartbeat interval. Once
+// Misses PINGs go unanswered the connection is considered dead, so the
+// caller can run its reconnect logic. A non zero until bounds the wait
+func (c *Client) awaitResponse(until time.Time) error {
+	interval := c.config.Heartbeat.Interval
+	if interval <= 0 {
+		return nil
+	}
+
+	for missed := 0; ; missed++ {
+		deadline := time.Now().Add(interval)
+		if !until.IsZero() && until.Before(deadline) {
+			deadline = until
+		}
+		c.conn.SetReadDeadline(deadline)
+		// Peek does not consume the frame, so a timeout loses no data
+		_, err := c.reader.Peek(1)
+		if err == nil {
+			return nil
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			return err
+		}
+		if !until.IsZero() && !time.Now().Before(until) {
+			return err
+		}
+
+		if missed >= c.config.Heartbeat.Misses {
+			err := errors.Errorf("connection dead after %d missed heartbeats", missed)
+			log.Warningf("action: heartbeat | result: fail | client_id: %v | server: %v | error: %v", c.config.ID, c.address, err)
+			return err
+		}
+		if missed > 0 {
+			log.Debugf("action: heartbeat | result: in_progress | client_id: %v | server: %v | missed: %v", c.config.ID, c.address, missed)
+		}
+		if err := c.send(&Message{Type: MsgPing}); err != nil {
+			return errors.Wrap(err, "could not send heartbeat")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestHeartbeatKeepsSlowServerAlive(t *testing.T) {
+	server := newTestServer(t)
+	server.delay = 120 * time.Millisecond
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     1,
+		Heartbeat:       HeartbeatConfig{Interval: 50 * time.Millisecond, Misses: 3},
+	})
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 30, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, _ := server.stored("1"); stored != 30 {
+		t.Fatalf("server stored %d of 30 bets", stored)
+	}
+	if client.stats.retries != 0 {
+		t.Fatalf("a slow server answering heartbeats was retried %d times", client.stats.retries)
+	}
+}
+
+// newSilentServer Returns the address of a server that accepts
+// connections but never answers, like the peer of a half-open connection
+func newSilentServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestHeartbeatDeclaresSilentServerDead(t *testing.T) {
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{newSilentServer(t)},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     1,
+		Heartbeat:       HeartbeatConfig{Interval: 30 * time.Millisecond, Misses: 2},
+	})
+
+	start := time.Now()
+	err := client.UploadBatches(NewSyntheticBatchSource("1", 10, 10, 1))
+	if err == nil || !strings.Contains(err.Error(), "missed heartbeats") {
+		t.Fatalf("expected the connection to be declared dead, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dead connection detected after %v", elapsed)
+	}
+}
+
+func TestHeartbeatKeepsWaitWinnersAlive(t *testing.T) {
+	server := newTestServer(t)
+	server.push = true
+	time.AfterFunc(200*time.Millisecond, server.draw)
+	client := newWinnersClient(server, time.Second)
+	client.config.Heartbeat = HeartbeatConfig{Interval: 30 * time.Millisecond, Misses: 2}
+
+	winners := 0
+	err := client.waitWinners(func(documents []string) error {
+		winners += len(documents)
+		return nil
+	})
+	if err != nil || winners != 2 {
+		t.Fatalf("got %d winners (%v), want 2 pushed", winners, err)
+	}
+}
+
+func TestHeartbeatDetectsDeadServerDuringWaitWinners(t *testing.T) {
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{newSilentServer(t)},
+		Heartbeat:       HeartbeatConfig{Interval: 30 * time.Millisecond, Misses: 2},
+		WinnersWait:     10 * time.Second,
+	})
+
+	start := time.Now()
+	err := client.waitWinners(func([]string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "missed heartbeats") {
+		t.Fatalf("expected the connection to be declared dead, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dead connection detected after %v", elapsed)
+	}
+}
+
+func TestHeartbeatsDoNotExtendResponseTimeout(t *testing.T) {
+	// The server answers every PING but never the messages of the client
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			msg, err := ReceiveMessage(reader)
+			if err != nil {
+				return
+			}
+			if msg.Type != MsgPing {
+				continue
+			}
+			if err := SendMessage(conn, &Message{Type: MsgPong}); err != nil {
+				return
+			}
+		}
+	}()
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{listener.Addr().String()},
+		ResponseTimeout: 150 * time.Millisecond,
+		Heartbeat:       HeartbeatConfig{Interval: 20 * time.Millisecond, Misses: 2},
+	})
+	if err := client.createClientSocket(); err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer client.closeClientSocket()
+
+	start := time.Now()
+	_, err = client.exchange(NewSessionMessage(MsgGetWinners, "1", DefaultContest))
+	if netErr, ok := errors.Cause(err).(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected the response to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("response timed out after %v despite a timeout of 150ms", elapsed)
+	}
+}
@@ -0,0 +1,92 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newEchoServer Server that answers every line it receives with the line
+// returned by answer
+func newEchoServer(t *testing.T, answer func(line string) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte(answer(line))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestProbeRoundTrip(t *testing.T) {
+	address := newEchoServer(t, func(line string) string { return line })
+	client := NewClient(ClientConfig{ID: "1", ServerAddresses: []string{address}})
+
+	if err := client.Probe(); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+}
+
+func TestProbeFailsOnUnexpectedEcho(t *testing.T) {
+	address := newEchoServer(t, func(line string) string { return "ERROR\n" })
+	client := NewClient(ClientConfig{ID: "1", ServerAddresses: []string{address}})
+
+	if err := client.Probe(); err == nil {
+		t.Fatalf("expected the probe to fail")
+	}
+}
+
+func TestProbeFailsWithoutAnswer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+	// The server accepts the connection but never answers
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{listener.Addr().String()},
+		ResponseTimeout: 50 * time.Millisecond,
+	})
+
+	start := time.Now()
+	err = client.Probe()
+	if netErr, ok := errors.Cause(err).(net.Error); !ok || !netErr.Timeout() {
+		t.Fatalf("expected the probe to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("probe gave up after %v", elapsed)
+	}
+}
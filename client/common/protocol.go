@@ -0,0 +1,357 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message types sent by the client
+const (
+	MsgBetBatch   = "BET_BATCH"
+	MsgBatchEnd   = "BATCH_END"
+	MsgGetWinners = "GET_WINNERS"
+	// MsgPing Heartbeat sent while waiting for a response
+	MsgPing = "PING"
+	// MsgWaitWinners Subscribes to the draw. The server keeps the
+	// connection open and answers WINNERS once the draw takes place
+	MsgWaitWinners = "WAIT_WINNERS"
+	// MsgGetDigest Requests the digest of the batches of the agency up to
+	// a sequence number, to find the first one that differs
+	MsgGetDigest = "GET_DIGEST"
+)
+
+// Message types sent by the server
+const (
+	MsgAck     = "ACK"
+	MsgError   = "ERROR"
+	MsgWinners = "WINNERS"
+	// MsgWinnersPart Part of the winners when they do not fit in a single
+	// frame. More parts follow on the same connection and the last one is
+	// sent as WINNERS
+	MsgWinnersPart = "WINNERS_PART"
+	// MsgDraw Winning number of the draw. The server may send it before
+	// the winners so the client can verify them
+	MsgDraw = "DRAW"
+	// MsgDigest Answer to GET_DIGEST with the digest of the batches
+	MsgDigest = "DIGEST"
+	// MsgBusy The server is overloaded. The body holds the amount of
+	// milliseconds the client should wait before retrying
+	MsgBusy = "BUSY"
+	// MsgRejected Some bets of a batch are invalid and the batch was not
+	// stored. The body holds the sequence number of the batch followed by
+	// the index and reason of every invalid bet
+	MsgRejected = "REJECTED"
+	// MsgPong Answer to a PING. It is sent after the responses to the
+	// messages received before the PING, except a pending WAIT_WINNERS,
+	// whose winners may be pushed after it
+	MsgPong = "PONG"
+)
+
+// ErrNotAllBatchesReceived Error code returned by the server when the
+// winners are requested before every agency finished its upload
+const ErrNotAllBatchesReceived = "NOT_ALL_BATCHES_RECEIVED"
+
+const (
+	// headerSize Size in bytes of the header that precedes every payload.
+	// The header holds the payload length as a big endian uint32
+	headerSize = 4
+	// MaxPayloadSize Maximum size in bytes of a message payload
+	MaxPayloadSize = 8 * 1024
+	// maxTypeSize Maximum size in bytes of a message type
+	maxTypeSize = 32
+	// DefaultContest Contest used when none is configured
+	DefaultContest = "default"
+	// MaxContestSize Maximum size in bytes of a contest identifier
+	MaxContestSize = 32
+	// maxBatchHeaderSize Space reserved at the start of a BET_BATCH body
+	// for the contest and the sequence number, each followed by a newline
+	maxBatchHeaderSize = MaxContestSize + 1 + 10 + 1
+	// typeSeparator Separates the message type from its body
+	typeSeparator = '\n'
+	// betSeparator Separates the bets inside a batch and the documents
+	// inside a winners list
+	betSeparator = string(listSeparator)
+)
+
+// Message A protocol message: a type followed by an optional body
+type Message struct {
+	Type string
+	Body []byte
+}
+
+// Encode Serializes the message as a length prefixed frame
+func (m *Message) Encode() ([]byte, error) {
+	payloadSize := len(m.Type) + 1 + len(m.Body)
+	if payloadSize > MaxPayloadSize {
+		return nil, errors.Errorf("payload of %d bytes exceeds the maximum of %d", payloadSize, MaxPayloadSize)
+	}
+
+	frame := make([]byte, headerSize, headerSize+payloadSize)
+	binary.BigEndian.PutUint32(frame, uint32(payloadSize))
+	frame = append(frame, m.Type...)
+	frame = append(frame, typeSeparator)
+	frame = append(frame, m.Body...)
+	return frame, nil
+}
+
+// DecodeMessage Parses a payload (without its header) into a message
+func DecodeMessage(payload []byte) (*Message, error) {
+	idx := bytes.IndexByte(payload, typeSeparator)
+	if idx <= 0 {
+		return nil, errors.New("malformed message: missing type")
+	}
+	if idx > maxTypeSize {
+		return nil, errors.Errorf("malformed message: type of %d bytes exceeds the maximum of %d", idx, maxTypeSize)
+	}
+	return &Message{
+		Type: string(payload[:idx]),
+		Body: payload[idx+1:],
+	}, nil
+}
+
+// writeAll Writes the whole buffer to the connection, retrying on
+// short writes
+func writeAll(conn net.Conn, data []byte) error {
+	for len(data) > 0 {
+		n, err := conn.Write(data)
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// SendMessage Encodes the message and writes it to the connection
+func SendMessage(conn net.Conn, msg *Message) error {
+	frame, err := msg.Encode()
+	if err != nil {
+		return err
+	}
+	return writeAll(conn, frame)
+}
+
+// ReceiveMessage Reads a full frame from the reader and decodes it.
+// io.ReadFull is used so short reads are handled transparently
+func ReceiveMessage(reader *bufio.Reader) (*Message, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	payloadSize := binary.BigEndian.Uint32(header)
+	if payloadSize > MaxPayloadSize {
+		return nil, errors.Errorf("payload of %d bytes exceeds the maximum of %d", payloadSize, MaxPayloadSize)
+	}
+
+	payload := make([]byte, payloadSize)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	return DecodeMessage(payload)
+}
+
+// DecodeRetryAfter Parses the body of a BUSY message. ok is false if the
+// body does not hold a valid hint
+func DecodeRetryAfter(body []byte) (retryAfter time.Duration, ok bool) {
+	millis, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 64)
+	if err != nil || millis < 0 {
+		return 0, false
+	}
+	// Avoid overflowing time.Duration with absurd hints
+	if millis > math.MaxInt64/int64(time.Millisecond) {
+		return time.Duration(math.MaxInt64), true
+	}
+	return time.Duration(millis) * time.Millisecond, true
+}
+
+// ValidateContest Checks that contest can be used as a contest identifier
+func ValidateContest(contest string) error {
+	if contest == "" || len(contest) > MaxContestSize {
+		return errors.Errorf("contest %q must have between 1 and %d bytes", contest, MaxContestSize)
+	}
+	if strings.ContainsAny(contest, "\n\r") {
+		return errors.Errorf("contest %q must not contain line breaks", contest)
+	}
+	return nil
+}
+
+// NewSessionMessage Builds a message whose body identifies the agency and
+// the contest it refers to: BATCH_END, GET_WINNERS or WAIT_WINNERS
+func NewSessionMessage(msgType string, agency string, contest string) *Message {
+	return &Message{Type: msgType, Body: []byte(agency + string(typeSeparator) + contest)}
+}
+
+// NewBatchEndMessage Builds the BATCH_END message of a contest. The body
+// holds the agency, the contest and the digest of every bet acknowledged
+func NewBatchEndMessage(agency string, contest string, digest Digest) *Message {
+	msg := NewSessionMessage(MsgBatchEnd, agency, contest)
+	msg.Body = append(msg.Body, typeSeparator)
+	msg.Body = append(msg.Body, digest.String()...)
+	return msg
+}
+
+// NewDigestRequest Builds the GET_DIGEST message for the batches of a
+// contest up to last
+func NewDigestRequest(agency string, contest string, last int) *Message {
+	msg := NewSessionMessage(MsgGetDigest, agency, contest)
+	msg.Body = append(msg.Body, typeSeparator)
+	msg.Body = append(msg.Body, strconv.Itoa(last)...)
+	return msg
+}
+
+// DecodeSession Parses the body of a message built with NewSessionMessage.
+// The lines that follow the contest, if any, are returned in extra
+func DecodeSession(body []byte) (agency string, contest string, extra string, err error) {
+	parts := bytes.SplitN(body, []byte{typeSeparator}, 3)
+	if len(parts) < 2 {
+		return "", "", "", errors.New("malformed message: missing contest")
+	}
+	if len(parts) == 3 {
+		extra = string(parts[2])
+	}
+	return string(parts[0]), string(parts[1]), extra, nil
+}
+
+// NewBatchMessage Builds the BET_BATCH message for a batch of a contest.
+// The body holds the contest and the sequence number of the batch, each
+// followed by a newline, and then the encoded bets
+func NewBatchMessage(contest string, seq int, bets []*Bet) *Message {
+	var buf bytes.Buffer
+	buf.WriteString(contest)
+	buf.WriteByte(typeSeparator)
+	buf.WriteString(strconv.Itoa(seq))
+	buf.WriteByte(typeSeparator)
+	buf.Write(EncodeBatch(bets))
+	return &Message{Type: MsgBetBatch, Body: buf.Bytes()}
+}
+
+// DecodeBatchMessage Parses the body of a BET_BATCH message into its
+// contest, sequence number and bets
+func DecodeBatchMessage(body []byte) (contest string, seq int, bets []*Bet, err error) {
+	parts := bytes.SplitN(body, []byte{typeSeparator}, 3)
+	if len(parts) != 3 {
+		return "", 0, nil, errors.New("malformed batch: missing contest or sequence number")
+	}
+	seq, ok := DecodeAckSeq(parts[1])
+	if !ok {
+		return "", 0, nil, errors.Errorf("malformed batch: invalid sequence number %q", parts[1])
+	}
+	bets, err = DecodeBatch(parts[2])
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return string(parts[0]), seq, bets, nil
+}
+
+// DecodeAckSeq Parses the sequence number acknowledged by an ACK
+func DecodeAckSeq(body []byte) (int, bool) {
+	seq, err := strconv.Atoi(string(body))
+	if err != nil {
+		return 0, false
+	}
+	return seq, true
+}
+
+// BetRejection A bet of a batch refused by the server
+type BetRejection struct {
+	Index  int
+	Reason string
+}
+
+// EncodeRejected Serializes the body of a REJECTED message. Reasons are
+// escaped like bet values, so they may hold the separators
+func EncodeRejected(seq int, rejections []BetRejection) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(strconv.Itoa(seq))
+	buf.WriteByte(typeSeparator)
+	for i, rejection := range rejections {
+		if i > 0 {
+			buf.WriteString(betSeparator)
+		}
+		buf.WriteString(strconv.Itoa(rejection.Index))
+		buf.WriteByte(keySeparator)
+		buf.WriteString(escapeValue(rejection.Reason))
+	}
+	return buf.Bytes()
+}
+
+// DecodeRejected Parses the body of a REJECTED message. The body has the
+// format seq\nindex:reason;index:reason, with the reasons escaped
+func DecodeRejected(body []byte) (int, []BetRejection, error) {
+	idx := bytes.IndexByte(body, typeSeparator)
+	if idx < 0 {
+		return 0, nil, errors.New("malformed rejection: missing sequence number")
+	}
+	seq, ok := DecodeAckSeq(body[:idx])
+	if !ok {
+		return 0, nil, errors.Errorf("malformed rejection: invalid sequence number %q", body[:idx])
+	}
+
+	rejections := []BetRejection{}
+	for _, entry := range splitEscaped(string(body[idx+1:]), listSeparator) {
+		if entry == "" {
+			continue
+		}
+		parts := splitEscaped(entry, keySeparator)
+		if len(parts) != 2 {
+			return 0, nil, errors.Errorf("malformed rejection entry %q", entry)
+		}
+		index, err := strconv.Atoi(parts[0])
+		if err != nil || index < 0 {
+			return 0, nil, errors.Errorf("malformed rejection entry %q", entry)
+		}
+		reason, err := unescapeValue(parts[1])
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "malformed rejection entry %q", entry)
+		}
+		rejections = append(rejections, BetRejection{Index: index, Reason: reason})
+	}
+	return seq, rejections, nil
+}
+
+// EncodeBatch Serializes a batch of bets as a message body
+func EncodeBatch(bets []*Bet) []byte {
+	var buf bytes.Buffer
+	for i, bet := range bets {
+		if i > 0 {
+			buf.WriteString(betSeparator)
+		}
+		buf.WriteString(bet.Encode())
+	}
+	return buf.Bytes()
+}
+
+// DecodeBatch Parses a batch of bets serialized with EncodeBatch
+func DecodeBatch(body []byte) ([]*Bet, error) {
+	bets := []*Bet{}
+	if len(body) == 0 {
+		return bets, nil
+	}
+	for i, encoded := range splitEscaped(string(body), listSeparator) {
+		bet, err := DecodeBet(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bet %d", i)
+		}
+		bets = append(bets, bet)
+	}
+	return bets, nil
+}
+
+// DecodeWinners Parses the body of a WINNERS message into the list of
+// winner documents
+func DecodeWinners(body []byte) []string {
+	if len(body) == 0 {
+		return []string{}
+	}
+	return strings.Split(string(body), betSeparator)
+}
@@ -0,0 +1,114 @@
+//go:build go1.18
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// maxDecodeAlloc Bytes a single frame may allocate while being decoded:
+// the header, the payload, a copy of the type and the error or message
+// values built around them
+const maxDecodeAlloc = headerSize + MaxPayloadSize + maxTypeSize + 1024
+
+// allocated Returns the bytes allocated on the heap while running fn
+func allocated(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// frame Encodes a message as a frame, panicking if it does not fit
+func frame(msg *Message) []byte {
+	encoded, err := msg.Encode()
+	if err != nil {
+		panic(err)
+	}
+	return encoded
+}
+
+// header Returns a frame header announcing size bytes of payload
+func header(size uint32) []byte {
+	encoded := make([]byte, headerSize)
+	binary.BigEndian.PutUint32(encoded, size)
+	return encoded
+}
+
+func FuzzReceiveMessage(f *testing.F) {
+	f.Add(frame(&Message{Type: MsgAck, Body: []byte("1")}))
+	f.Add(frame(&Message{Type: MsgWinners, Body: []byte("30904465;21689196")}))
+	f.Add(append(frame(&Message{Type: MsgBusy, Body: []byte("300")}), frame(&Message{Type: MsgAck})...))
+	f.Add(header(0xffffffff))
+	f.Add(header(MaxPayloadSize + 1))
+	f.Add(append(header(MaxPayloadSize), make([]byte, 16)...))
+	f.Add(append(header(3), "ACK"...))
+	f.Add([]byte{0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader := bufio.NewReader(bytes.NewReader(data))
+		for {
+			var msg *Message
+			var err error
+			if n := allocated(func() { msg, err = ReceiveMessage(reader) }); n > maxDecodeAlloc {
+				t.Fatalf("decoding a frame allocated %d bytes, maximum is %d", n, maxDecodeAlloc)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(msg.Type) == 0 || len(msg.Type) > maxTypeSize || strings.IndexByte(msg.Type, typeSeparator) >= 0 {
+				t.Fatalf("decoded invalid type %q", msg.Type)
+			}
+			encoded, err := msg.Encode()
+			if err != nil {
+				t.Fatalf("decoded message cannot be encoded again: %v", err)
+			}
+			decoded, err := DecodeMessage(encoded[headerSize:])
+			if err != nil || decoded.Type != msg.Type || !bytes.Equal(decoded.Body, msg.Body) {
+				t.Fatalf("round trip mismatch: got %+v (%v), want %+v", decoded, err, msg)
+			}
+		}
+	})
+}
+
+func FuzzDecodeResponse(f *testing.F) {
+	f.Add("1")
+	f.Add("300")
+	f.Add("-5")
+	f.Add("99999999999999999999")
+	f.Add("30904465;21689196;;")
+	f.Add("2\n8:number ends in 7")
+	f.Add("3\n0:number ends in 7;6:number ends in 7")
+	f.Add("3\n-1:negative;x:y")
+	f.Add(ErrNotAllBatchesReceived)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		if seq, ok := DecodeAckSeq([]byte(body)); ok && strings.TrimLeft(body, "+-0123456789") != "" {
+			t.Fatalf("ACK %q decoded as %d", body, seq)
+		}
+
+		if retryAfter, ok := DecodeRetryAfter([]byte(body)); ok && retryAfter < 0 {
+			t.Fatalf("BUSY %q decoded as negative pause %v", body, retryAfter)
+		}
+
+		if _, rejections, err := DecodeRejected([]byte(body)); err == nil {
+			for _, rejection := range rejections {
+				if rejection.Index < 0 {
+					t.Fatalf("REJECTED %q decoded with negative index %d", body, rejection.Index)
+				}
+			}
+		}
+
+		winners := DecodeWinners([]byte(body))
+		if joined := strings.Join(winners, betSeparator); joined != body {
+			t.Fatalf("WINNERS %q decoded as %q", body, winners)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package common
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimitConfig Limits applied to the outgoing batches. Rates are
+// expressed per second and a rate of zero disables that limit. Bursts
+// are the amount that can be sent at once after being idle; when zero
+// they default to one second worth of the rate
+type RateLimitConfig struct {
+	Bets         float64
+	BetsBurst    int
+	Batches      float64
+	BatchesBurst int
+	Bytes        float64
+	BytesBurst   int
+}
+
+// tokenBucket Classic token bucket. Requests larger than the available
+// tokens are admitted by leaving the bucket in debt, so the caller waits
+// until the debt is paid. This allows requests bigger than the burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = rate
+	}
+	return &tokenBucket{rate: rate, burst: capacity, tokens: capacity, last: now}
+}
+
+// reserve Takes n tokens from the bucket and returns how long the caller
+// has to wait before using them
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter Throttles outgoing batches according to a RateLimitConfig
+// and keeps track of the time spent waiting
+type RateLimiter struct {
+	mu        sync.Mutex
+	bets      *tokenBucket
+	batches   *tokenBucket
+	bytes     *tokenBucket
+	throttled time.Duration
+	waits     int
+}
+
+// NewRateLimiter Creates a limiter with full buckets
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	now := time.Now()
+	return &RateLimiter{
+		bets:    newTokenBucket(config.Bets, config.BetsBurst, now),
+		batches: newTokenBucket(config.Batches, config.BatchesBurst, now),
+		bytes:   newTokenBucket(config.Bytes, config.BytesBurst, now),
+	}
+}
+
+// Reserve Takes the tokens of a batch of the given amount of bets and
+// bytes and returns how long the caller has to wait before sending it
+func (l *RateLimiter) Reserve(bets int, bytes int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	delay := l.bets.reserve(float64(bets), now)
+	if d := l.batches.reserve(1, now); d > delay {
+		delay = d
+	}
+	if d := l.bytes.reserve(float64(bytes), now); d > delay {
+		delay = d
+	}
+	if delay > 0 {
+		l.throttled += delay
+		l.waits++
+	}
+	return delay
+}
+
+// Throttled Returns the total time spent waiting and the amount of
+// batches that had to wait
+func (l *RateLimiter) Throttled() (time.Duration, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.throttled, l.waits
+}
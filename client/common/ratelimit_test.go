@@ -0,0 +1,77 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	start := time.Now()
+	bucket := newTokenBucket(10, 5, start)
+
+	// The burst is available at once
+	if delay := bucket.reserve(5, start); delay != 0 {
+		t.Fatalf("burst delayed %v", delay)
+	}
+	// Each token past the burst takes a tenth of a second
+	if delay := bucket.reserve(2, start); delay != 200*time.Millisecond {
+		t.Fatalf("got delay %v, want 200ms", delay)
+	}
+	// The debt is paid before new tokens become available
+	if delay := bucket.reserve(1, start.Add(100*time.Millisecond)); delay != 200*time.Millisecond {
+		t.Fatalf("got delay %v, want 200ms", delay)
+	}
+	// An idle bucket refills up to its burst only
+	if delay := bucket.reserve(5, start.Add(time.Hour)); delay != 0 {
+		t.Fatalf("refilled burst delayed %v", delay)
+	}
+	if delay := bucket.reserve(1, start.Add(time.Hour)); delay != 100*time.Millisecond {
+		t.Fatalf("got delay %v, want 100ms", delay)
+	}
+}
+
+func TestTokenBucketDefaults(t *testing.T) {
+	start := time.Now()
+	if bucket := newTokenBucket(0, 10, start); bucket != nil || bucket.reserve(1000, start) != 0 {
+		t.Fatalf("a zero rate must disable the limit")
+	}
+	// Without a burst, one second worth of the rate is available
+	bucket := newTokenBucket(4, 0, start)
+	if delay := bucket.reserve(4, start); delay != 0 {
+		t.Fatalf("default burst delayed %v", delay)
+	}
+	// A request bigger than the burst is admitted in debt
+	if delay := bucket.reserve(8, start); delay != 2*time.Second {
+		t.Fatalf("got delay %v, want 2s", delay)
+	}
+}
+
+func TestUploadIsThrottled(t *testing.T) {
+	server := newTestServer(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		RateLimit:       RateLimitConfig{Batches: 10, BatchesBurst: 1},
+	})
+
+	start := time.Now()
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 50, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	// The limiter spaces the 5 batches at least 100ms apart, however
+	// long the server takes, while the amount of waits depends on it
+	if elapsed := time.Since(start); elapsed < 390*time.Millisecond {
+		t.Fatalf("5 batches at 10 per second took %v", elapsed)
+	}
+	throttled, waits := client.limiter.Throttled()
+	if waits < 1 || waits > 4 || throttled <= 0 {
+		t.Fatalf("throttled %v in %d waits, want between 1 and 4 waits", throttled, waits)
+	}
+	if summary := client.Summary(nil); summary.ThrottledSeconds != throttled.Seconds() || summary.BatchesThrottled != waits {
+		t.Fatalf("summary reports %vs in %d waits, want %v in %d", summary.ThrottledSeconds, summary.BatchesThrottled, throttled, waits)
+	}
+	if stored, finished := server.stored("1"); stored != 50 || !finished {
+		t.Fatalf("server stored %d of 50 bets (finished: %v)", stored, finished)
+	}
+}
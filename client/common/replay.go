@@ -0,0 +1,111 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// replayTimeout Maximum time to wait for each response of the server
+const replayTimeout = 10 * time.Second
+
+// ReplayMismatch A response of the server that differs from the captured
+// one. Got is nil if the response could not be received
+type ReplayMismatch struct {
+	Conn     int
+	Frame    int
+	Expected *Message
+	Got      *Message
+	Err      error
+}
+
+// ReplayReport Result of replaying a capture against a server
+type ReplayReport struct {
+	Connections int
+	Sent        int
+	Received    int
+	Mismatches  []ReplayMismatch
+}
+
+// Replay Sends the frames of a capture to the server at address, opening
+// a connection for every captured one, and compares each response with
+// the captured response. Frames are sent in the captured order so the
+// requests in flight are the same as in the original run
+func Replay(address string, records []CaptureRecord) (*ReplayReport, error) {
+	report := &ReplayReport{}
+	for start := 0; start < len(records); {
+		end := start
+		for end < len(records) && records[end].Conn == records[start].Conn {
+			end++
+		}
+		if err := report.replayConn(address, records[start:end]); err != nil {
+			return report, err
+		}
+		start = end
+	}
+	return report, nil
+}
+
+// replayConn Replays the frames of a single connection. Once a response
+// cannot be received the rest of the connection is skipped
+func (r *ReplayReport) replayConn(address string, records []CaptureRecord) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	r.Connections++
+
+	for i, record := range records {
+		// Heartbeats depend on the timing of the original run
+		if record.Type == MsgPing || record.Type == MsgPong {
+			continue
+		}
+		expected := record.Message()
+		if record.Direction == DirectionSent {
+			if err := SendMessage(conn, expected); err != nil {
+				r.Mismatches = append(r.Mismatches, ReplayMismatch{Conn: record.Conn, Frame: i + 1, Err: err})
+				return nil
+			}
+			r.Sent++
+			continue
+		}
+
+		conn.SetReadDeadline(time.Now().Add(replayTimeout))
+		got, err := ReceiveMessage(reader)
+		if err != nil {
+			r.Mismatches = append(r.Mismatches, ReplayMismatch{Conn: record.Conn, Frame: i + 1, Expected: expected, Err: err})
+			return nil
+		}
+		r.Received++
+		if got.Type != expected.Type || string(got.Body) != string(expected.Body) {
+			r.Mismatches = append(r.Mismatches, ReplayMismatch{Conn: record.Conn, Frame: i + 1, Expected: expected, Got: got})
+		}
+	}
+	return nil
+}
+
+// Write Prints the mismatches of the replay followed by a summary
+func (r *ReplayReport) Write(w io.Writer) {
+	for _, mismatch := range r.Mismatches {
+		fmt.Fprintf(w, "conn %d frame %d:\n", mismatch.Conn, mismatch.Frame)
+		if mismatch.Expected != nil {
+			fmt.Fprintf(w, "  - %s %q\n", mismatch.Expected.Type, mismatch.Expected.Body)
+		}
+		if mismatch.Got != nil {
+			fmt.Fprintf(w, "  + %s %q\n", mismatch.Got.Type, mismatch.Got.Body)
+		}
+		if mismatch.Err != nil {
+			fmt.Fprintf(w, "  ! %v\n", mismatch.Err)
+		}
+	}
+	fmt.Fprintf(w, "connections: %d  sent: %d  received: %d  mismatches: %d\n",
+		r.Connections,
+		r.Sent,
+		r.Received,
+		len(r.Mismatches),
+	)
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// birthdateLayout Format of the birthdate of a bet
+const birthdateLayout = "2006-01-02"
+
+// ValidationConfig Rules applied to every bet before it leaves the
+// agency. Each rule can be enabled independently
+type ValidationConfig struct {
+	DocumentEnabled   bool
+	DocumentMinDigits int
+	DocumentMaxDigits int
+
+	BirthdateEnabled bool
+	BirthdateMinYear int
+
+	AgeEnabled bool
+	MinAge     int
+
+	NumberEnabled bool
+	NumberMin     int
+	NumberMax     int
+
+	NamesEnabled bool
+}
+
+// Rule A named check over a bet
+type Rule struct {
+	Name  string
+	Check func(bet *Bet) error
+}
+
+// RuleError A bet that does not satisfy a rule
+type RuleError struct {
+	Rule string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return e.Rule + ": " + e.Err.Error()
+}
+
+// Validator Applies a set of rules to bets and counts the failures of
+// each rule
+type Validator struct {
+	rules    []Rule
+	failures map[string]int
+}
+
+// NewValidator Builds a validator with the rules enabled in config. now
+// is used as the reference date for age and birthdate checks
+func NewValidator(config ValidationConfig, now time.Time) *Validator {
+	rules := []Rule{}
+	if config.DocumentEnabled {
+		rules = append(rules, documentRule(config.DocumentMinDigits, config.DocumentMaxDigits))
+	}
+	if config.BirthdateEnabled {
+		rules = append(rules, birthdateRule(config.BirthdateMinYear, now))
+	}
+	if config.AgeEnabled {
+		rules = append(rules, ageRule(config.MinAge, now))
+	}
+	if config.NumberEnabled {
+		rules = append(rules, numberRule(config.NumberMin, config.NumberMax))
+	}
+	if config.NamesEnabled {
+		rules = append(rules, namesRule())
+	}
+	return &Validator{rules: rules, failures: map[string]int{}}
+}
+
+// Validate Applies every rule to the bet and returns a *RuleError for the
+// first one that fails
+func (v *Validator) Validate(bet *Bet) error {
+	for _, rule := range v.rules {
+		if err := rule.Check(bet); err != nil {
+			v.failures[rule.Name]++
+			return &RuleError{Rule: rule.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// LogSummary Logs the amount of failures of every rule that failed
+func (v *Validator) LogSummary(agency string) {
+	names := make([]string, 0, len(v.failures))
+	for name := range v.failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		log.Warningf("action: validation_rule | result: fail | client_id: %v | rule: %v | cantidad: %v",
+			agency,
+			name,
+			v.failures[name],
+		)
+	}
+}
+
+// Failures Returns the amount of failures of each rule
+func (v *Validator) Failures() map[string]int {
+	return v.failures
+}
+
+func documentRule(minDigits int, maxDigits int) Rule {
+	return Rule{Name: "document", Check: func(bet *Bet) error {
+		for _, r := range bet.Document {
+			if r < '0' || r > '9' {
+				return errors.Errorf("document %q must only contain digits", bet.Document)
+			}
+		}
+		if digits := len(bet.Document); digits < minDigits || digits > maxDigits {
+			return errors.Errorf("document %q must have between %d and %d digits", bet.Document, minDigits, maxDigits)
+		}
+		return nil
+	}}
+}
+
+func birthdateRule(minYear int, now time.Time) Rule {
+	return Rule{Name: "birthdate", Check: func(bet *Bet) error {
+		birthdate, err := time.Parse(birthdateLayout, bet.Birthdate)
+		if err != nil {
+			return errors.Errorf("birthdate %q is not a YYYY-MM-DD date", bet.Birthdate)
+		}
+		if birthdate.Year() < minYear || birthdate.After(now) {
+			return errors.Errorf("birthdate %q must be between %d and today", bet.Birthdate, minYear)
+		}
+		return nil
+	}}
+}
+
+func ageRule(minAge int, now time.Time) Rule {
+	return Rule{Name: "age", Check: func(bet *Bet) error {
+		birthdate, err := time.Parse(birthdateLayout, bet.Birthdate)
+		if err != nil {
+			return errors.Errorf("birthdate %q is not a YYYY-MM-DD date", bet.Birthdate)
+		}
+		if birthdate.AddDate(minAge, 0, 0).After(now) {
+			return errors.Errorf("bettor born on %s is younger than %d", bet.Birthdate, minAge)
+		}
+		return nil
+	}}
+}
+
+func numberRule(min int, max int) Rule {
+	return Rule{Name: "number", Check: func(bet *Bet) error {
+		if bet.Number < min || bet.Number > max {
+			return errors.Errorf("number %d must be between %d and %d", bet.Number, min, max)
+		}
+		return nil
+	}}
+}
+
+func namesRule() Rule {
+	return Rule{Name: "names", Check: func(bet *Bet) error {
+		for _, name := range []string{bet.FirstName, bet.LastName} {
+			if strings.TrimSpace(name) == "" {
+				return errors.New("first and last name must not be empty")
+			}
+			for _, r := range name {
+				if unicode.IsControl(r) {
+					return errors.Errorf("name %q contains control characters", name)
+				}
+			}
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// validBet Bet of an adult that satisfies every rule of testValidation
+func validBet() *Bet {
+	return &Bet{
+		Agency:    "1",
+		FirstName: "Santiago Lionel",
+		LastName:  "Lorca",
+		Document:  "30904465",
+		Birthdate: "1999-03-17",
+		Number:    7574,
+	}
+}
+
+func testValidation() ValidationConfig {
+	return ValidationConfig{
+		DocumentEnabled:   true,
+		DocumentMinDigits: 7,
+		DocumentMaxDigits: 8,
+		BirthdateEnabled:  true,
+		BirthdateMinYear:  1900,
+		AgeEnabled:        true,
+		MinAge:            18,
+		NumberEnabled:     true,
+		NumberMin:         0,
+		NumberMax:         9999,
+		NamesEnabled:      true,
+	}
+}
+
+func TestValidationRuleBoundaries(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		change func(bet *Bet)
+		// rule Rule expected to fail, empty if the bet is valid
+		rule string
+	}{
+		{"valid", func(bet *Bet) {}, ""},
+		{"document with min digits", func(bet *Bet) { bet.Document = "1234567" }, ""},
+		{"document below min digits", func(bet *Bet) { bet.Document = "123456" }, "document"},
+		{"document above max digits", func(bet *Bet) { bet.Document = "123456789" }, "document"},
+		{"document with letters", func(bet *Bet) { bet.Document = "3090446A" }, "document"},
+		{"document with sign", func(bet *Bet) { bet.Document = "-3090446" }, "document"},
+		{"birthdate in min year", func(bet *Bet) { bet.Birthdate = "1900-01-01" }, ""},
+		{"birthdate before min year", func(bet *Bet) { bet.Birthdate = "1899-12-31" }, "birthdate"},
+		{"birthdate in the future", func(bet *Bet) { bet.Birthdate = "2024-06-16" }, "birthdate"},
+		{"birthdate not a date", func(bet *Bet) { bet.Birthdate = "17/03/1999" }, "birthdate"},
+		{"birthdate not in the calendar", func(bet *Bet) { bet.Birthdate = "1999-02-30" }, "birthdate"},
+		{"min age on the birthday", func(bet *Bet) { bet.Birthdate = "2006-06-15" }, ""},
+		{"min age the day before the birthday", func(bet *Bet) { bet.Birthdate = "2006-06-16" }, "age"},
+		{"number at min", func(bet *Bet) { bet.Number = 0 }, ""},
+		{"number at max", func(bet *Bet) { bet.Number = 9999 }, ""},
+		{"number below min", func(bet *Bet) { bet.Number = -1 }, "number"},
+		{"number above max", func(bet *Bet) { bet.Number = 10000 }, "number"},
+		{"accented names", func(bet *Bet) { bet.FirstName, bet.LastName = "María José", "Núñez" }, ""},
+		{"empty first name", func(bet *Bet) { bet.FirstName = "" }, "names"},
+		{"blank last name", func(bet *Bet) { bet.LastName = " \t" }, "names"},
+		{"name with control characters", func(bet *Bet) { bet.LastName = "Lor\x00ca" }, "names"},
+	}
+
+	for _, c := range cases {
+		bet := validBet()
+		c.change(bet)
+		err := NewValidator(testValidation(), now).Validate(bet)
+		if c.rule == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		if ruleErr, ok := errors.Cause(err).(*RuleError); !ok || ruleErr.Rule != c.rule {
+			t.Errorf("%s: got %v, want a %s rule error", c.name, err, c.rule)
+		}
+	}
+}
+
+func TestDisabledRulesAreNotApplied(t *testing.T) {
+	bet := &Bet{Document: "x", Birthdate: "never", Number: -1}
+	validator := NewValidator(ValidationConfig{}, time.Now())
+	if err := validator.Validate(bet); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestValidatorCountsFailuresPerRule(t *testing.T) {
+	validator := NewValidator(testValidation(), time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC))
+	for _, document := range []string{"1", "2", "30904465"} {
+		bet := validBet()
+		bet.Document = document
+		validator.Validate(bet)
+	}
+	bet := validBet()
+	bet.Number = 10000
+	validator.Validate(bet)
+
+	if failures := validator.Failures(); len(failures) != 2 || failures["document"] != 2 || failures["number"] != 1 {
+		t.Fatalf("unexpected failures %v", failures)
+	}
+}
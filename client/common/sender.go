@@ -0,0 +1,282 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pendingBatch A batch that has not been acknowledged by the server yet
+type pendingBatch struct {
+	seq    int
+	bets   []*Bet
+	msg    *Message
+	sentAt time.Time
+}
+
+// BatchRejectedError The server refused a batch. Sending it again would
+// not help, so the upload is aborted
+type BatchRejectedError struct {
+	Seq    int
+	Reason string
+}
+
+func (e *BatchRejectedError) Error() string {
+	return fmt.Sprintf("batch %d rejected: %s", e.Seq, e.Reason)
+}
+
+// windowSender Keeps up to size batches in flight on a single connection.
+// The server answers batches in order, so the oldest pending batch is
+// always the one the next response refers to. On a failure every pending
+// batch is sent again starting from the oldest one (go-back-N)
+type windowSender struct {
+	client    *Client
+	source    BatchSource
+	size      int
+	window    []*pendingBatch
+	inflight  int
+	nextSeq   int
+	exhausted bool
+	failures  int
+}
+
+func newWindowSender(client *Client, source BatchSource) *windowSender {
+	size := client.config.BatchWindow
+	if size <= 0 {
+		size = 1
+	}
+	return &windowSender{
+		client:  client,
+		source:  source,
+		size:    size,
+		nextSeq: 1,
+	}
+}
+
+// run Sends every batch of the source and returns once all of them have
+// been acknowledged
+func (s *windowSender) run() error {
+	for {
+		if err := s.fill(); err != nil {
+			return err
+		}
+		if len(s.window) == 0 {
+			return nil
+		}
+
+		err := s.transmit()
+		if err == nil {
+			err = s.receive()
+		}
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(*BatchRejectedError); ok {
+			return err
+		}
+		if err := s.recover(err); err != nil {
+			return err
+		}
+	}
+}
+
+// fill Reads batches from the source until the window is full
+func (s *windowSender) fill() error {
+	for !s.exhausted && len(s.window) < s.size {
+		bets, err := s.source.NextBatch()
+		if err == io.EOF {
+			s.exhausted = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		s.window = append(s.window, &pendingBatch{
+			seq:  s.nextSeq,
+			bets: bets,
+			msg:  NewBatchMessage(s.client.config.Contest, s.nextSeq, bets),
+		})
+		s.nextSeq++
+	}
+	return nil
+}
+
+// transmit Sends the batches of the window that are not in flight
+func (s *windowSender) transmit() error {
+	for s.inflight < len(s.window) {
+		batch := s.window[s.inflight]
+		msg := batch.msg
+		if delay := s.client.limiter.Reserve(len(batch.bets), headerSize+len(msg.Type)+1+len(msg.Body)); delay > 0 {
+			log.Debugf("action: rate_limit | result: in_progress | client_id: %v | delay: %v", s.client.config.ID, delay)
+			if err := s.client.pause(delay); err != nil {
+				return err
+			}
+		}
+
+		batch.sentAt = time.Now()
+		if err := s.client.send(msg); err != nil {
+			return errors.Wrapf(err, "could not send batch %d", batch.seq)
+		}
+		s.inflight++
+	}
+	return nil
+}
+
+// receive Waits for the response to the oldest batch in flight
+func (s *windowSender) receive() error {
+	response, err := s.client.receive()
+	if err != nil {
+		return errors.Wrap(err, "could not receive batch response")
+	}
+	batch := s.window[0]
+	s.inflight--
+
+	switch response.Type {
+	case MsgAck:
+		if seq, ok := DecodeAckSeq(response.Body); !ok || seq != batch.seq {
+			return errors.Errorf("expected ACK for batch %d, got %q", batch.seq, response.Body)
+		}
+		s.window = s.window[1:]
+		s.failures = 0
+		s.client.stats.batchesSent++
+		s.client.digest.ack(batch.seq, batch.bets)
+		log.Debugf("action: apuesta_enviada | result: success | client_id: %v | batch: %v | cantidad: %v",
+			s.client.config.ID,
+			batch.seq,
+			len(batch.bets),
+		)
+		if s.client.onAck != nil {
+			s.client.onAck(batch.bets, time.Since(batch.sentAt))
+		}
+		return nil
+	case MsgBusy:
+		if err := s.discardInflight(); err != nil {
+			return err
+		}
+		s.client.waitBackpressure(response)
+		return nil
+	case MsgRejected:
+		seq, rejections, err := DecodeRejected(response.Body)
+		if err != nil {
+			return err
+		}
+		if seq != batch.seq {
+			return errors.Errorf("expected rejection for batch %d, got %d", batch.seq, seq)
+		}
+		if err := s.removeRejected(batch, rejections); err != nil {
+			return err
+		}
+		return s.discardInflight()
+	case MsgError:
+		err := &BatchRejectedError{Seq: batch.seq, Reason: string(response.Body)}
+		log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | batch: %v | cantidad: %v | error: %v",
+			s.client.config.ID,
+			batch.seq,
+			len(batch.bets),
+			err,
+		)
+		return err
+	default:
+		return errors.Errorf("unexpected response %s to batch %d", response.Type, batch.seq)
+	}
+}
+
+// discardInflight Reads and ignores the responses to the batches sent
+// after a refused one. They are sent again afterwards and the server is
+// expected to ignore the ones it already stored
+func (s *windowSender) discardInflight() error {
+	for ; s.inflight > 0; s.inflight-- {
+		if _, err := s.client.receive(); err != nil {
+			return errors.Wrap(err, "could not receive batch response")
+		}
+	}
+	return nil
+}
+
+// removeRejected Reports the bets refused by the server and replaces the
+// batch with the valid remainder, keeping its sequence number. A batch
+// left empty is dropped instead, as it would name no agency
+func (s *windowSender) removeRejected(batch *pendingBatch, rejections []BetRejection) error {
+	reasons := make(map[int]string, len(rejections))
+	for _, rejection := range rejections {
+		if rejection.Index >= len(batch.bets) {
+			return errors.Errorf("rejection of bet %d in batch %d of %d bets", rejection.Index, batch.seq, len(batch.bets))
+		}
+		reasons[rejection.Index] = rejection.Reason
+	}
+
+	remainder := make([]*Bet, 0, len(batch.bets)-len(reasons))
+	for i, bet := range batch.bets {
+		reason, rejected := reasons[i]
+		if !rejected {
+			remainder = append(remainder, bet)
+			continue
+		}
+		s.client.rejectBet(bet, reason)
+	}
+
+	if len(remainder) == 0 {
+		s.dropOldest()
+		return nil
+	}
+	batch.bets = remainder
+	batch.msg = NewBatchMessage(s.client.config.Contest, batch.seq, remainder)
+	return nil
+}
+
+// dropOldest Removes the oldest batch of the window, which the server did
+// not store, and numbers the following ones from its sequence number so
+// sequence numbers have no gaps. The server did not store the following
+// batches either, as they came after a missing sequence number
+func (s *windowSender) dropOldest() {
+	dropped := s.window[0]
+	log.Infof("action: batch_descartado | result: success | client_id: %v | batch: %v", s.client.config.ID, dropped.seq)
+	s.window = s.window[1:]
+	for _, batch := range s.window {
+		batch.seq--
+		batch.msg = NewBatchMessage(s.client.config.Contest, batch.seq, batch.bets)
+	}
+	s.nextSeq--
+}
+
+// recover Reopens the connection after a failure so every pending batch
+// is sent again. Gives up after BatchRetries consecutive failures
+func (s *windowSender) recover(cause error) error {
+	// A shut down client closes its connection, which is not a failure
+	if s.client.Stopped() {
+		return ErrShutdown
+	}
+	s.failures++
+	log.Warningf("action: apuesta_enviada | result: fail | client_id: %v | batch: %v | attempt: %v | error: %v",
+		s.client.config.ID,
+		s.window[0].seq,
+		s.failures,
+		cause,
+	)
+	if s.client.onRetry != nil {
+		s.client.onRetry()
+	}
+	if s.failures > s.client.config.BatchRetries {
+		return cause
+	}
+	s.client.stats.retries++
+
+	// The session is resumed on the next available server from the
+	// oldest unacknowledged sequence number
+	if s.client.conn != nil {
+		s.client.ejectServer(s.client.address, cause)
+	}
+	s.client.closeClientSocket()
+	s.inflight = 0
+	if err := s.client.pause(s.client.config.LoopPeriod); err != nil {
+		return err
+	}
+	if err := s.client.createClientSocket(); err != nil {
+		// Another attempt is made on the next round
+		return s.recover(err)
+	}
+	s.client.stats.reconnections++
+	return nil
+}
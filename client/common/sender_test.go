@@ -0,0 +1,324 @@
+package common
+
+import (
+	"bufio"
+	"encoding/csv"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWindowKeepsSeveralBatchesInFlight(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		reader := bufio.NewReader(conn)
+		// Nothing is answered until the whole window arrives
+		for i := 0; i < 4; i++ {
+			if _, err := ReceiveMessage(reader); err != nil {
+				return
+			}
+		}
+		for seq := 1; seq <= 4; seq++ {
+			SendMessage(conn, &Message{Type: MsgAck, Body: []byte(strconv.Itoa(seq))})
+		}
+		if _, err := ReceiveMessage(reader); err == nil {
+			SendMessage(conn, &Message{Type: MsgAck})
+		}
+	}()
+
+	acked := 0
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{listener.Addr().String()},
+		BatchMaxAmount:  10,
+		BatchWindow:     4,
+	})
+	client.onAck = func(bets []*Bet, latency time.Duration) { acked++ }
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 40, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if acked != 4 {
+		t.Fatalf("%d batches acknowledged, want 4", acked)
+	}
+}
+
+func TestMismatchedAckIsRetried(t *testing.T) {
+	server := newTestServer(t)
+	server.misackOnce = 2
+	retries := 0
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     4,
+		BatchRetries:    3,
+	})
+	client.onRetry = func() { retries++ }
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 60, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if stored, finished := server.stored("1"); stored != 60 || !finished {
+		t.Fatalf("server stored %d of 60 bets (finished: %v)", stored, finished)
+	}
+	if retries != 1 {
+		t.Fatalf("got %d retries, want 1", retries)
+	}
+}
+
+func TestRecoveryResumesFromOldestUnacked(t *testing.T) {
+	server := newTestServer(t)
+	server.dropOnce = 3
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     4,
+		BatchRetries:    3,
+	})
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 60, 10, 1)); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	// Batch 3 was stored but never acknowledged, so it is sent again
+	// along with the ones in flight after it
+	if want := []int{1, 2, 3, 3, 4, 5, 6}; !reflect.DeepEqual(server.received, want) {
+		t.Fatalf("server received batches %v, want %v", server.received, want)
+	}
+	if stored, finished := server.stored("1"); stored != 60 || !finished {
+		t.Fatalf("server stored %d of 60 bets (finished: %v)", stored, finished)
+	}
+}
+
+func TestUploadGivesUpAfterRetries(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Every batch gets the ACK of another one
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := ReceiveMessage(reader); err != nil {
+						return
+					}
+					SendMessage(conn, &Message{Type: MsgAck, Body: []byte("99")})
+				}
+			}()
+		}
+	}()
+
+	retries := 0
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{listener.Addr().String()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchRetries:    2,
+	})
+	client.onRetry = func() { retries++ }
+
+	if err := client.UploadBatches(NewSyntheticBatchSource("1", 10, 10, 1)); err == nil {
+		t.Fatalf("expected the upload to fail")
+	}
+	if retries != 3 {
+		t.Fatalf("got %d failed attempts, want 3", retries)
+	}
+}
+
+// sendWithRejections Uploads an agency file of 25 bets in batches of 10
+// while the server rejects the given lines, and returns the client and
+// the rows of the dead letter file
+func sendWithRejections(t *testing.T, server *testServer, lines ...int) (*Client, [][]string) {
+	server.rejectDocuments = map[string]bool{}
+	for _, line := range lines {
+		server.rejectDocuments[strconv.Itoa(30000000+line)] = true
+	}
+	path := writeAgencyFile(t, sequentialBets(25))
+	deadLetter := filepath.Join(t.TempDir(), "rejected.csv")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     2,
+		DeadLetterFile:  deadLetter,
+	})
+
+	if err := client.SendBets(path); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	file, err := os.Open(deadLetter)
+	if os.IsNotExist(err) {
+		return client, nil
+	}
+	if err != nil {
+		t.Fatalf("could not open dead letter file: %v", err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("could not read dead letter file: %v", err)
+	}
+	return client, rows[1:]
+}
+
+func TestPartiallyRejectedBatchIsResent(t *testing.T) {
+	server := newTestServer(t)
+	client, rows := sendWithRejections(t, server, 3, 12)
+
+	if stored, finished := server.stored("1"); stored != 23 || !finished {
+		t.Fatalf("server stored %d of 23 bets (finished: %v)", stored, finished)
+	}
+	if client.rejectedBets != 2 {
+		t.Fatalf("rejected %d bets, want 2", client.rejectedBets)
+	}
+	if len(rows) != 2 || rows[0][0] != "3" || rows[0][1] != "invalid_document" || rows[1][4] != "30000012" {
+		t.Fatalf("unexpected dead letter rows %v", rows)
+	}
+}
+
+func TestFullyRejectedBatchIsDropped(t *testing.T) {
+	server := newTestServer(t)
+	// Every bet of the second batch is rejected
+	lines := []int{}
+	for line := 11; line <= 20; line++ {
+		lines = append(lines, line)
+	}
+	client, rows := sendWithRejections(t, server, lines...)
+
+	if stored, finished := server.stored("1"); stored != 15 || !finished {
+		t.Fatalf("server stored %d of 15 bets (finished: %v)", stored, finished)
+	}
+	if len(rows) != 10 || client.stats.batchesSent != 2 {
+		t.Fatalf("got %d dead letter rows and %d batches, want 10 and 2", len(rows), client.stats.batchesSent)
+	}
+}
+
+func TestDeadLetterKeepsLocalAndServerRejections(t *testing.T) {
+	server := newTestServer(t)
+	server.rejectDocuments = map[string]bool{"30000002": true}
+	path := writeAgencyFile(t, sequentialBets(3))
+	appendRows(t, path, "Nombre,Apellido,30000004,1990-01-01\n")
+	deadLetter := filepath.Join(t.TempDir(), "rejected.csv")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		DeadLetterFile:  deadLetter,
+	})
+
+	if err := client.SendBets(path); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	content, err := os.ReadFile(deadLetter)
+	if err != nil {
+		t.Fatalf("could not read dead letter file: %v", err)
+	}
+	reader := csv.NewReader(strings.NewReader(string(content)))
+	// The row without number keeps the fields it had
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("unexpected dead letter file %q: %v", content, err)
+	}
+	if !reflect.DeepEqual(rows[0], deadLetterHeader) || rows[1][0] != "2" || rows[2][0] != "4" {
+		t.Fatalf("unexpected dead letter rows %v", rows)
+	}
+}
+
+func TestDeadLetterIsOnlyCreatedWithRejections(t *testing.T) {
+	server := newTestServer(t)
+	client, rows := sendWithRejections(t, server)
+	if rows != nil || client.rejectedBets != 0 {
+		t.Fatalf("got dead letter rows %v without rejections", rows)
+	}
+}
+
+func TestDeadLetterOfPreviousRunIsRemoved(t *testing.T) {
+	server := newTestServer(t)
+	path := writeAgencyFile(t, sequentialBets(5))
+	deadLetter := filepath.Join(t.TempDir(), "rejected.csv")
+	if err := os.WriteFile(deadLetter, []byte("line,reason\n1,stale\n"), 0644); err != nil {
+		t.Fatalf("could not write dead letter file: %v", err)
+	}
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		DeadLetterFile:  deadLetter,
+	})
+
+	if err := client.SendBets(path); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if _, err := os.Stat(deadLetter); !os.IsNotExist(err) {
+		t.Fatalf("dead letter file of the previous run still exists: %v", err)
+	}
+}
+
+func TestServerErrorAbortsUpload(t *testing.T) {
+	server := newTestServer(t)
+	// A batch past the next sequence number is refused with ERROR
+	server.lastSeq[sessionKey("1", DefaultContest)] = -1
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		BatchRetries:    3,
+	})
+
+	err := client.UploadBatches(NewSyntheticBatchSource("1", 10, 10, 1))
+	if rejected, ok := err.(*BatchRejectedError); !ok || rejected.Seq != 1 || rejected.Reason != "SEQUENCE_GAP" {
+		t.Fatalf("got %v, want batch 1 rejected", err)
+	}
+}
+
+func TestDecodeRejected(t *testing.T) {
+	seq, rejections, err := DecodeRejected([]byte("3\n0:invalid_document;6:number out of range"))
+	if err != nil || seq != 3 {
+		t.Fatalf("got batch %d (%v), want 3", seq, err)
+	}
+	want := []BetRejection{{Index: 0, Reason: "invalid_document"}, {Index: 6, Reason: "number out of range"}}
+	if !reflect.DeepEqual(rejections, want) {
+		t.Fatalf("got rejections %+v, want %+v", rejections, want)
+	}
+
+	// Reasons holding the separators survive the round trip
+	want = []BetRejection{{Index: 2, Reason: `birthdate: 2030-01-01; in the future \ check`}, {Index: 4, Reason: "a|b"}}
+	seq, rejections, err = DecodeRejected(EncodeRejected(5, want))
+	if err != nil || seq != 5 || !reflect.DeepEqual(rejections, want) {
+		t.Fatalf("got batch %d rejections %+v (%v), want 5 %+v", seq, rejections, err, want)
+	}
+
+	for _, body := range []string{"3", "x\n0:a", "3\nbad", "3\n-1:negative", "3\n0:a:b", `3\n0:bad\x`} {
+		if _, _, err := DecodeRejected([]byte(body)); err == nil {
+			t.Fatalf("%q must be malformed", body)
+		}
+	}
+}
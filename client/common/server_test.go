@@ -0,0 +1,284 @@
+package common
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer Minimal lottery server that stores every batch once: a
+// batch with a sequence number already stored is acknowledged again
+// without storing it, as expected by the go-back-N sender. The winners
+// are answered once the draw takes place
+type testServer struct {
+	listener net.Listener
+	// delay Time taken to store each batch
+	delay time.Duration
+	mu    sync.Mutex
+	// lastSeq, bets, finished and digests Are kept per agency and
+	// contest, as each contest has its own sequence of batches
+	lastSeq  map[string]int
+	bets     map[string]int
+	finished map[string]bool
+	digests  map[string][]Digest
+	// loseBetOf Sequence number of a batch whose last bet is lost by the
+	// server after acknowledging it. 0 loses no bet
+	loseBetOf int
+	// rejectDocuments Bets refused with REJECTED, by document. A batch
+	// with any of them is not stored
+	rejectDocuments map[string]bool
+	// noDigest Answers BATCH_END with an empty ACK, like a server
+	// without digests
+	noDigest bool
+	// busy Hints of the BUSY responses given to the next batches and
+	// BATCH_END messages, instead of handling them
+	busy []string
+	// received Sequence numbers of every batch received, in order
+	received []int
+	// dropOnce Sequence number of a batch that is stored and answered by
+	// closing the connection the first time it arrives. 0 drops none
+	dropOnce int
+	// misackOnce Sequence number of a batch answered with the ACK of the
+	// next one, without storing it, the first time it arrives. 0 answers
+	// every batch properly
+	misackOnce int
+	// push Answers WAIT_WINNERS once the draw takes place, otherwise it
+	// is refused as an unknown message
+	push    bool
+	winners string
+	// winnersPart Maximum amount of winners per frame. 0 sends all of
+	// them in a single WINNERS frame
+	winnersPart int
+	// drawNumber Winning number sent in a DRAW frame before the winners.
+	// Empty sends no DRAW frame
+	drawNumber string
+	drawn      chan struct{}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	server := &testServer{
+		listener: listener,
+		lastSeq:  map[string]int{},
+		bets:     map[string]int{},
+		finished: map[string]bool{},
+		digests:  map[string][]Digest{},
+		winners:  "30904465;21689196",
+		drawn:    make(chan struct{}),
+	}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *testServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	var writeMu sync.Mutex
+	write := func(response *Message) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		for _, frame := range s.split(response) {
+			if err := SendMessage(conn, frame); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for {
+		msg, err := ReceiveMessage(reader)
+		if err != nil {
+			return
+		}
+		// The winners are pushed once the draw takes place, while the
+		// following messages, such as PINGs, are answered
+		if msg.Type == MsgWaitWinners && s.push {
+			go func() {
+				<-s.drawn
+				write(&Message{Type: MsgWinners, Body: []byte(s.winners)})
+			}()
+			continue
+		}
+		response := s.respond(msg)
+		if response == nil {
+			return
+		}
+		if err := write(response); err != nil {
+			return
+		}
+	}
+}
+
+// draw Makes the winners available
+func (s *testServer) draw() {
+	close(s.drawn)
+}
+
+func (s *testServer) isDrawn() bool {
+	select {
+	case <-s.drawn:
+		return true
+	default:
+		return false
+	}
+}
+
+// respond Returns the response to msg, or nil to close the connection
+func (s *testServer) respond(msg *Message) *Message {
+	if msg.Type == MsgBetBatch {
+		time.Sleep(s.delay)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.busy) > 0 && (msg.Type == MsgBetBatch || msg.Type == MsgBatchEnd) {
+		hint := s.busy[0]
+		s.busy = s.busy[1:]
+		return &Message{Type: MsgBusy, Body: []byte(hint)}
+	}
+	switch msg.Type {
+	case MsgPing:
+		return &Message{Type: MsgPong}
+	case MsgBetBatch:
+		contest, seq, bets, err := DecodeBatchMessage(msg.Body)
+		if err != nil || len(bets) == 0 {
+			return &Message{Type: MsgError, Body: []byte("MALFORMED")}
+		}
+		session := sessionKey(bets[0].Agency, contest)
+		s.received = append(s.received, seq)
+		if seq == s.misackOnce {
+			s.misackOnce = 0
+			return &Message{Type: MsgAck, Body: []byte(strconv.Itoa(seq + 1))}
+		}
+		switch {
+		case seq == s.lastSeq[session]+1:
+			if rejections := s.rejections(bets); len(rejections) > 0 {
+				return &Message{Type: MsgRejected, Body: EncodeRejected(seq, rejections)}
+			}
+			s.lastSeq[session] = seq
+			if seq == s.loseBetOf {
+				bets = bets[:len(bets)-1]
+			}
+			s.bets[session] += len(bets)
+			var digest Digest
+			digest.Add(bets)
+			s.digests[session] = append(s.digests[session], digest)
+			if seq == s.dropOnce {
+				s.dropOnce = 0
+				return nil
+			}
+		case seq > s.lastSeq[session]+1:
+			return &Message{Type: MsgError, Body: []byte("SEQUENCE_GAP")}
+		}
+		return &Message{Type: MsgAck, Body: []byte(strconv.Itoa(seq))}
+	case MsgBatchEnd:
+		agency, contest, _, err := DecodeSession(msg.Body)
+		if err != nil {
+			return &Message{Type: MsgError, Body: []byte("MALFORMED")}
+		}
+		session := sessionKey(agency, contest)
+		s.finished[session] = true
+		if s.noDigest {
+			return &Message{Type: MsgAck}
+		}
+		return &Message{Type: MsgAck, Body: []byte(s.digest(session, len(s.digests[session])).String())}
+	case MsgGetDigest:
+		agency, contest, last, err := DecodeSession(msg.Body)
+		seq, ok := DecodeAckSeq([]byte(last))
+		if err != nil || !ok {
+			return &Message{Type: MsgError, Body: []byte("MALFORMED")}
+		}
+		return &Message{Type: MsgDigest, Body: []byte(s.digest(sessionKey(agency, contest), seq).String())}
+	case MsgGetWinners:
+		if !s.isDrawn() {
+			return &Message{Type: MsgError, Body: []byte(ErrNotAllBatchesReceived)}
+		}
+		return &Message{Type: MsgWinners, Body: []byte(s.winners)}
+	default:
+		return &Message{Type: MsgError, Body: []byte("UNKNOWN")}
+	}
+}
+
+// digest Returns the digest of the batches of session up to last
+func (s *testServer) digest(session string, last int) Digest {
+	var digest Digest
+	for i := 0; i < last && i < len(s.digests[session]); i++ {
+		digest.Merge(s.digests[session][i])
+	}
+	return digest
+}
+
+// split Splits a WINNERS response in WINNERS_PART frames of winnersPart
+// winners followed by a last WINNERS frame, preceded by the DRAW frame
+func (s *testServer) split(response *Message) []*Message {
+	if response.Type != MsgWinners {
+		return []*Message{response}
+	}
+	parts := []*Message{}
+	if s.drawNumber != "" {
+		parts = append(parts, &Message{Type: MsgDraw, Body: []byte(s.drawNumber)})
+	}
+	if s.winnersPart <= 0 {
+		return append(parts, response)
+	}
+	winners := DecodeWinners(response.Body)
+	for len(winners) > s.winnersPart {
+		body := strings.Join(winners[:s.winnersPart], betSeparator)
+		parts = append(parts, &Message{Type: MsgWinnersPart, Body: []byte(body)})
+		winners = winners[s.winnersPart:]
+	}
+	return append(parts, &Message{Type: MsgWinners, Body: []byte(strings.Join(winners, betSeparator))})
+}
+
+// rejections Returns the bets of a batch refused by the server, or none
+// if all of them are valid
+func (s *testServer) rejections(bets []*Bet) []BetRejection {
+	rejections := []BetRejection{}
+	for i, bet := range bets {
+		if s.rejectDocuments[bet.Document] {
+			rejections = append(rejections, BetRejection{Index: i, Reason: "invalid_document"})
+		}
+	}
+	return rejections
+}
+
+// stored Returns the bets stored for agency in the default contest and
+// whether it finished
+func (s *testServer) stored(agency string) (int, bool) {
+	return s.storedIn(agency, DefaultContest)
+}
+
+// storedIn Returns the bets stored for agency in contest and whether it
+// finished
+func (s *testServer) storedIn(agency string, contest string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session := sessionKey(agency, contest)
+	return s.bets[session], s.finished[session]
+}
+
+func sessionKey(agency string, contest string) string {
+	return agency + "/" + contest
+}
@@ -0,0 +1,48 @@
+package common
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShutdown Returned by the operations of a client interrupted by
+// Shutdown
+var ErrShutdown = errors.New("client shut down")
+
+// Shutdown Interrupts the client from another goroutine. The current
+// connection is closed, so a blocked read or write returns, and no new
+// connection is opened. It is safe to call it several times
+func (c *Client) Shutdown() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.connMu.Lock()
+		defer c.connMu.Unlock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+	})
+}
+
+// Stopped Returns true once Shutdown has been called
+func (c *Client) Stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// pause Waits for d unless the client is shut down in the meantime.
+// Returns ErrShutdown in that case
+func (c *Client) pause(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.stop:
+		return ErrShutdown
+	}
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShutdownInterruptsUpload(t *testing.T) {
+	server := newTestServer(t)
+	server.delay = 50 * time.Millisecond
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Second,
+		BatchMaxAmount:  10,
+		BatchWindow:     1,
+		BatchRetries:    5,
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- client.UploadBatches(NewSyntheticBatchSource("1", 1000, 10, 1))
+	}()
+	time.Sleep(120 * time.Millisecond)
+	client.Shutdown()
+
+	select {
+	case err := <-done:
+		if err != ErrShutdown {
+			t.Fatalf("got %v, want %v", err, ErrShutdown)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("upload still running after shutdown")
+	}
+	if stored, finished := server.stored("1"); stored == 1000 || finished {
+		t.Fatalf("upload finished despite the shutdown: %d bets stored", stored)
+	}
+	if summary := client.Summary(ErrShutdown); summary.Status != StatusInterrupted {
+		t.Fatalf("summary status %v, want %v", summary.Status, StatusInterrupted)
+	}
+	if err := client.createClientSocket(); err != ErrShutdown {
+		t.Fatalf("connected after shutdown: %v", err)
+	}
+}
+
+func TestShutdownInterruptsRateLimitWait(t *testing.T) {
+	server := newTestServer(t)
+	// After the first batch every batch waits about ten seconds
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		BatchMaxAmount:  10,
+		RateLimit:       RateLimitConfig{Batches: 0.1, BatchesBurst: 1},
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- client.UploadBatches(NewSyntheticBatchSource("1", 30, 10, 1))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	client.Shutdown()
+
+	select {
+	case err := <-done:
+		if err != ErrShutdown {
+			t.Fatalf("got %v, want %v", err, ErrShutdown)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("rate limit wait not interrupted by the shutdown")
+	}
+}
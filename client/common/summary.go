@@ -0,0 +1,143 @@
+package common
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+)
+
+// Final status of a run
+const (
+	StatusSuccess = "success"
+	StatusFail    = "fail"
+	// StatusInterrupted The run was stopped by Shutdown before finishing
+	StatusInterrupted = "interrupted"
+)
+
+// runStats Counters collected by the client during a run
+type runStats struct {
+	started       time.Time
+	file          string
+	rowsRead      int
+	rowsRejected  int
+	duplicates    int
+	batchesSent   int
+	retries       int
+	reconnections int
+	bytesIn       int64
+	bytesOut      int64
+	winners       int
+}
+
+// RunSummary Machine readable summary of a client run
+type RunSummary struct {
+	Agency           string    `json:"agency"`
+	Contest          string    `json:"contest"`
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	File             string    `json:"file"`
+	RowsRead         int       `json:"rows_read"`
+	RowsRejected     int       `json:"rows_rejected"`
+	Duplicates       int       `json:"duplicates"`
+	BatchesSent      int       `json:"batches_sent"`
+	Retries          int       `json:"retries"`
+	Reconnections    int       `json:"reconnections"`
+	BytesIn          int64     `json:"bytes_in"`
+	BytesOut         int64     `json:"bytes_out"`
+	Winners          int       `json:"winners"`
+	ThrottledSeconds float64   `json:"throttled_seconds"`
+	BatchesThrottled int       `json:"batches_throttled"`
+	Status           string    `json:"status"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// countingConn Connection that adds the bytes read and written to the
+// stats of the client
+type countingConn struct {
+	net.Conn
+	stats *runStats
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.bytesIn += int64(n)
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.bytesOut += int64(n)
+	return n, err
+}
+
+// Summary Returns the summary of the run so far. err is the error that
+// ended the run, if any
+func (c *Client) Summary(err error) *RunSummary {
+	summary := &RunSummary{
+		Agency:        c.config.ID,
+		Contest:       c.config.Contest,
+		Start:         c.stats.started,
+		End:           time.Now(),
+		File:          c.stats.file,
+		RowsRead:      c.stats.rowsRead,
+		RowsRejected:  c.stats.rowsRejected,
+		Duplicates:    c.stats.duplicates,
+		BatchesSent:   c.stats.batchesSent,
+		Retries:       c.stats.retries,
+		Reconnections: c.stats.reconnections,
+		BytesIn:       c.stats.bytesIn,
+		BytesOut:      c.stats.bytesOut,
+		Winners:       c.stats.winners,
+		Status:        StatusSuccess,
+	}
+	throttled, waits := c.limiter.Throttled()
+	summary.ThrottledSeconds = throttled.Seconds()
+	summary.BatchesThrottled = waits
+	if err != nil {
+		summary.Status = StatusFail
+		if c.Stopped() {
+			summary.Status = StatusInterrupted
+		}
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+// WriteSummary Logs the summary of the run and writes it as JSON to the
+// configured summary file, if any
+func (c *Client) WriteSummary(err error) error {
+	summary := c.Summary(err)
+	throttled, _ := c.limiter.Throttled()
+	log.Infof("action: summary | result: %v | client_id: %v | contest: %v | file: %v | rows_read: %v | rows_rejected: %v | duplicates: %v | batches_sent: %v | retries: %v | reconnections: %v | bytes_in: %v | bytes_out: %v | winners: %v | throttled: %v | batches_throttled: %v | duration: %v",
+		summary.Status,
+		summary.Agency,
+		summary.Contest,
+		summary.File,
+		summary.RowsRead,
+		summary.RowsRejected,
+		summary.Duplicates,
+		summary.BatchesSent,
+		summary.Retries,
+		summary.Reconnections,
+		summary.BytesIn,
+		summary.BytesOut,
+		summary.Winners,
+		throttled.Round(time.Millisecond),
+		summary.BatchesThrottled,
+		summary.End.Sub(summary.Start).Round(time.Millisecond),
+	)
+
+	if c.config.SummaryFile == "" {
+		return nil
+	}
+	if err := writeFileAtomic(c.config.SummaryFile, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}); err != nil {
+		log.Errorf("action: summary | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	return nil
+}
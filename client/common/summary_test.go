@@ -0,0 +1,84 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSummaryCountsUpload(t *testing.T) {
+	server := newTestServer(t)
+	server.rejectDocuments = map[string]bool{"30000003": true}
+	// Batch 2 is in flight when batch 1 is rejected, so its response is
+	// discarded and the wrong ACK is given to batch 3 instead
+	server.misackOnce = 3
+	path := writeAgencyFile(t, sequentialBets(25))
+	// A repetition of line 1 and a row without number
+	appendRows(t, path, "Nombre,Apellido,30000001,1990-01-01,0\nNombre,Apellido,30000027,1990-01-01\n")
+	summaryFile := filepath.Join(t.TempDir(), "summary.json")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopPeriod:      time.Millisecond,
+		BatchMaxAmount:  10,
+		BatchWindow:     2,
+		BatchRetries:    3,
+		Duplicates:      DuplicateConfig{Policy: DuplicateDrop, ExactMaxSize: 1 << 20, FilterBits: 1 << 10},
+		SummaryFile:     summaryFile,
+	})
+
+	uploadErr := client.SendBets(path)
+	if uploadErr != nil {
+		t.Fatalf("upload failed: %v", uploadErr)
+	}
+	if err := client.WriteSummary(uploadErr); err != nil {
+		t.Fatalf("could not write summary: %v", err)
+	}
+	content, err := os.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatalf("could not read summary: %v", err)
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(content, &fields); err != nil {
+		t.Fatalf("could not decode summary %s: %v", content, err)
+	}
+
+	want := map[string]interface{}{
+		"agency":        "1",
+		"file":          path,
+		"rows_read":     27.0,
+		"rows_rejected": 3.0,
+		"duplicates":    1.0,
+		"batches_sent":  3.0,
+		"retries":       1.0,
+		"reconnections": 1.0,
+		"status":        StatusSuccess,
+	}
+	for field, value := range want {
+		if fields[field] != value {
+			t.Errorf("%s: got %v, want %v", field, fields[field], value)
+		}
+	}
+	if fields["bytes_out"].(float64) <= 0 || fields["bytes_in"].(float64) <= 0 {
+		t.Errorf("no traffic accounted: %s", content)
+	}
+	if _, ok := fields["error"]; ok {
+		t.Errorf("successful run reported an error: %s", content)
+	}
+}
+
+func TestSummaryStatus(t *testing.T) {
+	client := NewClient(ClientConfig{ID: "1"})
+	if summary := client.Summary(nil); summary.Status != StatusSuccess || summary.Error != "" {
+		t.Fatalf("got status %q and error %q, want a successful run", summary.Status, summary.Error)
+	}
+	if summary := client.Summary(os.ErrClosed); summary.Status != StatusFail || summary.Error != os.ErrClosed.Error() {
+		t.Fatalf("got status %q and error %q, want a failed run", summary.Status, summary.Error)
+	}
+	client.Shutdown()
+	if summary := client.Summary(os.ErrClosed); summary.Status != StatusInterrupted {
+		t.Fatalf("got status %q, want %q", summary.Status, StatusInterrupted)
+	}
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"io"
+)
+
+// ValidationReport Outcome of checking an agency file offline
+type ValidationReport struct {
+	Rows    int
+	Valid   int
+	Invalid int
+}
+
+// ValidateBets Parses every row of the agency file located at path and
+// checks it against the validation rules without contacting the server.
+// Invalid rows are logged with their line number and counted in the
+// returned report
+func (c *Client) ValidateBets(path string) (*ValidationReport, error) {
+	agency := c.config.ID
+	reader, err := NewBatchReader(agency, path, c.config.BetsCharset, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	reader.SetValidator(c.validator)
+	defer c.validator.LogSummary(agency)
+
+	report := &ValidationReport{}
+	for {
+		_, err := reader.ReadBet()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*RowError); err != nil && !ok {
+			return nil, err
+		}
+		report.Rows++
+		if err != nil {
+			report.Invalid++
+			log.Warningf("action: validate_bet | result: fail | client_id: %v | error: %v", agency, err)
+			continue
+		}
+		report.Valid++
+	}
+	return report, nil
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateBetsCountsInvalidRows(t *testing.T) {
+	rows := "Santiago,Lorca,30904465,1999-03-17,7574\n" +
+		"Camila,Zambrano,123,1999-03-17,1\n" +
+		"Tiago,Rivera,30904467,1999-03-17\n" +
+		"Agustin,Varela,30904468,1999-03-17,2\n"
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	client := NewClient(ClientConfig{ID: "1", Validation: testValidation()})
+
+	report, err := client.ValidateBets(path)
+	if err != nil {
+		t.Fatalf("validation failed: %v", err)
+	}
+	if *report != (ValidationReport{Rows: 4, Valid: 2, Invalid: 2}) {
+		t.Fatalf("unexpected report %+v", *report)
+	}
+	if failures := client.validator.Failures(); failures["document"] != 1 {
+		t.Fatalf("unexpected rule failures %v", failures)
+	}
+}
+
+func TestValidateBetsFailsWithoutFile(t *testing.T) {
+	client := NewClient(ClientConfig{ID: "1"})
+	if _, err := client.ValidateBets(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+}
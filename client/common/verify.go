@@ -0,0 +1,89 @@
+package common
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// winnersVerifier Compares the winners returned by the server with the
+// ones computed from the agency file for the winning number
+type winnersVerifier struct {
+	number int
+	// bets Uploaded bets on the winning number by document, which are the
+	// expected winners. The winners export joins them too
+	bets agencyBets
+	// returned Expected documents the server returned
+	returned map[string]bool
+	received int
+	extra    []string
+}
+
+// newWinnersVerifier Returns a verifier expecting the documents of bets,
+// the uploaded bets for number
+func newWinnersVerifier(bets agencyBets, number int) *winnersVerifier {
+	return &winnersVerifier{number: number, bets: bets, returned: map[string]bool{}}
+}
+
+// add Checks the winner documents of a frame of the server. Nothing is
+// checked if the winning number is unknown
+func (v *winnersVerifier) add(documents []string) {
+	if v.number < 0 {
+		return
+	}
+	for _, document := range documents {
+		v.received++
+		if _, ok := v.bets[document]; !ok {
+			v.extra = append(v.extra, document)
+			continue
+		}
+		v.returned[document] = true
+	}
+}
+
+// missing Returns the expected documents the server did not return
+func (v *winnersVerifier) missing() []string {
+	missing := []string{}
+	for document := range v.bets {
+		if !v.returned[document] {
+			missing = append(missing, document)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// log Logs the outcome of the verification and returns an error if the
+// server answer does not match the agency file
+func (v *winnersVerifier) log(agency string) error {
+	missing := v.missing()
+	if len(missing) == 0 && len(v.extra) == 0 {
+		log.Infof("action: verificacion_ganadores | result: success | client_id: %v | numero: %v | cant_ganadores: %v",
+			agency,
+			v.number,
+			len(v.bets),
+		)
+		return nil
+	}
+
+	log.Errorf("action: verificacion_ganadores | result: fail | client_id: %v | numero: %v | esperados: %v | recibidos: %v | faltantes: %v | sobrantes: %v",
+		agency,
+		v.number,
+		len(v.bets),
+		v.received,
+		strings.Join(missing, betSeparator),
+		strings.Join(v.extra, betSeparator),
+	)
+	return errors.Errorf("winners do not match the agency file: %d missing and %d extra", len(missing), len(v.extra))
+}
+
+// DecodeDraw Parses the body of a DRAW message into the winning number
+func DecodeDraw(body []byte) (int, bool) {
+	number, err := strconv.Atoi(string(body))
+	if err != nil || number < 0 {
+		return 0, false
+	}
+	return number, true
+}
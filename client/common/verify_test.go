@@ -0,0 +1,68 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// verifyWinners Queries the winners returned by server for an agency
+// file with two winners of 7574 and verifies them
+func verifyWinners(t *testing.T, server *testServer, number int) error {
+	betsPath := filepath.Join(t.TempDir(), "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Agustin Emanuel,Zambrano,21689196,2000-05-10,7574\n" +
+		"Maria,Perez,11111111,1990-01-01,1234\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	server.draw()
+	client := newWinnersClient(server, 0)
+	client.config.VerifyWinners = true
+	client.config.WinningNumber = number
+	return client.QueryAndExportWinners(betsPath)
+}
+
+func TestVerifyWinnersWithConfiguredNumber(t *testing.T) {
+	server := newTestServer(t)
+	if err := verifyWinners(t, server, 7574); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+}
+
+func TestVerifyWinnersWithDrawMessage(t *testing.T) {
+	server := newTestServer(t)
+	server.drawNumber = "7574"
+	server.winnersPart = 1
+	if err := verifyWinners(t, server, -1); err != nil {
+		t.Fatalf("verification failed: %v", err)
+	}
+}
+
+func TestVerifyWinnersReportsMismatch(t *testing.T) {
+	server := newTestServer(t)
+	server.drawNumber = "7574"
+	server.winners = "30904465;11111111"
+	err := verifyWinners(t, server, -1)
+	if err == nil || !strings.Contains(err.Error(), "1 missing and 1 extra") {
+		t.Fatalf("expected a mismatch, got %v", err)
+	}
+}
+
+func TestVerifyWinnersWithoutNumber(t *testing.T) {
+	server := newTestServer(t)
+	server.winners = "11111111"
+	if err := verifyWinners(t, server, -1); err != nil {
+		t.Fatalf("verification without winning number failed: %v", err)
+	}
+}
+
+func TestVerifyWinnersDrawTakesPrecedence(t *testing.T) {
+	server := newTestServer(t)
+	server.drawNumber = "7574"
+	if err := verifyWinners(t, server, 1234); err != nil {
+		t.Fatalf("verification against the configured number instead of DRAW: %v", err)
+	}
+}
@@ -0,0 +1,487 @@
+package common
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// winnersHeader Columns of the winners CSV file
+var winnersHeader = []string{"document", "first_name", "last_name", "birthdate", "number", "line"}
+
+// Winner A winner document returned by the server joined with the bet
+// data of the agency file. Found is false if the document is not in the
+// agency file, in which case only Document is set
+type Winner struct {
+	Document  string `json:"document"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Birthdate string `json:"birthdate,omitempty"`
+	Number    *int   `json:"number,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Found     bool   `json:"found"`
+}
+
+// winnersFile Content of the winners JSON file
+type winnersFile struct {
+	Agency  string   `json:"agency"`
+	Winners []Winner `json:"winners"`
+}
+
+// JoinWinners Looks up the bets of the winner documents in the agency file
+// located at path. The server only returns documents, so only the bets
+// for number are reported. A negative number, unknown, reports every bet
+// of a winner document
+func (c *Client) JoinWinners(path string, documents []string, number int) ([]Winner, error) {
+	bets, err := c.loadDocumentBets(path, documents)
+	if err != nil {
+		return nil, err
+	}
+	return bets.join(c.config.ID, documents, number), nil
+}
+
+// agencyBets Bets of an agency file by document
+type agencyBets map[string][]*Bet
+
+// loadAgencyBets Reads the bets of the agency file located at path that
+// keep selects, so only those are held in memory. Invalid rows and bets
+// refused by the server during this run are skipped, as well as the bets
+// that break a rule of validator, if any
+func (c *Client) loadAgencyBets(path string, validator *Validator, keep func(bet *Bet) bool) (agencyBets, error) {
+	reader, err := NewBatchReader(c.config.ID, path, c.config.BetsCharset, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if validator != nil {
+		reader.SetValidator(validator)
+	}
+
+	bets := agencyBets{}
+	for {
+		bet, err := reader.ReadBet()
+		if err == io.EOF {
+			return bets, nil
+		}
+		if _, ok := err.(*RowError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !c.rejectedLines[bet.Line] && keep(bet) {
+			bets[bet.Document] = append(bets[bet.Document], bet)
+		}
+	}
+}
+
+// loadDocumentBets Reads the bets of the given documents from the agency
+// file located at path
+func (c *Client) loadDocumentBets(path string, documents []string) (agencyBets, error) {
+	wanted := make(map[string]bool, len(documents))
+	for _, document := range documents {
+		wanted[document] = true
+	}
+	return c.loadAgencyBets(path, nil, func(bet *Bet) bool { return wanted[bet.Document] })
+}
+
+// loadWinningBets Reads the bets for number that were uploaded from the
+// agency file located at path, that is valid bets not refused by the
+// server during this run. Only these can be winners
+func (c *Client) loadWinningBets(path string, number int) (agencyBets, error) {
+	// The validator of the client counts the failures of the upload
+	validator := NewValidator(c.config.Validation, time.Now())
+	return c.loadAgencyBets(path, validator, func(bet *Bet) bool { return bet.Number == number })
+}
+
+// join Returns the bets of the winner documents for number, in the order
+// of documents. A negative number reports every bet of a document
+func (b agencyBets) join(agency string, documents []string, number int) []Winner {
+	winners := []Winner{}
+	for _, document := range documents {
+		found := false
+		for _, bet := range b[document] {
+			if number >= 0 && bet.Number != number {
+				continue
+			}
+			found = true
+			betNumber := bet.Number
+			winners = append(winners, Winner{
+				Document:  bet.Document,
+				FirstName: bet.FirstName,
+				LastName:  bet.LastName,
+				Birthdate: bet.Birthdate,
+				Number:    &betNumber,
+				Line:      bet.Line,
+				Found:     true,
+			})
+		}
+		if found {
+			continue
+		}
+		log.Warningf("action: cruce_ganadores | result: fail | client_id: %v | dni: %v | numero: %v | error: no bet of the document in agency file",
+			agency,
+			document,
+			number,
+		)
+		winners = append(winners, Winner{Document: document})
+	}
+	return winners
+}
+
+// winnersExport Writes the winners to the configured CSV and JSON files
+// as they arrive, so they are never held in memory all at once. The files
+// replace the previous ones only once every winner has been written
+type winnersExport struct {
+	client   *Client
+	betsPath string
+	// bets Bets on the winning number, read with the first winners. Nil
+	// while the winning number is unknown
+	bets     agencyBets
+	csvFile  *atomicFile
+	csv      *csv.Writer
+	jsonFile *atomicFile
+	written  int
+}
+
+// newWinnersExport Creates the temporary files of the configured formats
+func (c *Client) newWinnersExport(betsPath string) (*winnersExport, error) {
+	export := &winnersExport{client: c, betsPath: betsPath}
+	if c.config.WinnersCSV != "" {
+		file, err := createAtomicFile(c.config.WinnersCSV)
+		if err != nil {
+			return nil, err
+		}
+		export.csvFile = file
+		export.csv = csv.NewWriter(file)
+		if err := export.csv.Write(winnersHeader); err != nil {
+			export.abort()
+			return nil, errors.Wrapf(err, "could not write %s", c.config.WinnersCSV)
+		}
+	}
+	if c.config.WinnersJSON != "" {
+		file, err := createAtomicFile(c.config.WinnersJSON)
+		if err != nil {
+			export.abort()
+			return nil, err
+		}
+		export.jsonFile = file
+		agency, _ := json.Marshal(c.config.ID)
+		fmt.Fprintf(file, "{\n  \"agency\": %s,\n  \"winners\": [", agency)
+	}
+	return export, nil
+}
+
+// add Joins the winner documents with the agency file and appends them
+// to the files. If the winning number is known only the bets on it are
+// read, once, as no other bet can be exported. Otherwise every bet of a
+// winner is exported, so the file is read again for each frame keeping
+// only the bets of its documents. Either way memory does not depend on
+// the size of the agency file
+func (e *winnersExport) add(documents []string) error {
+	if e.csvFile == nil && e.jsonFile == nil {
+		return nil
+	}
+	number := e.client.winningNumber()
+	bets := e.bets
+	if bets == nil {
+		var err error
+		if number >= 0 {
+			bets, err = e.client.loadWinningBets(e.betsPath, number)
+			e.bets = bets
+		} else {
+			bets, err = e.client.loadDocumentBets(e.betsPath, documents)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, winner := range bets.join(e.client.config.ID, documents, number) {
+		if e.csv != nil {
+			if err := e.csv.Write(winnerRow(winner)); err != nil {
+				return errors.Wrapf(err, "could not write %s", e.csvFile.path)
+			}
+		}
+		if e.jsonFile != nil {
+			content, err := json.MarshalIndent(winner, "    ", "  ")
+			if err != nil {
+				return err
+			}
+			separator := ","
+			if e.written == 0 {
+				separator = ""
+			}
+			if _, err := fmt.Fprintf(e.jsonFile, "%s\n    %s", separator, content); err != nil {
+				return errors.Wrapf(err, "could not write %s", e.jsonFile.path)
+			}
+		}
+		e.written++
+	}
+	return nil
+}
+
+// commit Completes the files and moves them to their final paths. Both
+// files are written completely before any of them is moved, so a write
+// error leaves the previous files in place
+func (e *winnersExport) commit() error {
+	staged := []*atomicFile{}
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			e.abort()
+			return errors.Wrapf(err, "could not write %s", e.csvFile.path)
+		}
+		staged = append(staged, e.csvFile)
+	}
+	if e.jsonFile != nil {
+		end := "]\n}\n"
+		if e.written > 0 {
+			end = "\n  ]\n}\n"
+		}
+		e.jsonFile.WriteString(end)
+		staged = append(staged, e.jsonFile)
+	}
+	for _, file := range staged {
+		if err := file.stage(); err != nil {
+			e.abort()
+			return err
+		}
+	}
+
+	e.csvFile, e.jsonFile = nil, nil
+	for i, file := range staged {
+		if err := file.rename(); err != nil {
+			for _, pending := range staged[i+1:] {
+				pending.Abort()
+			}
+			if i > 0 {
+				return errors.Wrapf(err, "only %s was replaced", staged[0].path)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// abort Discards the files not committed yet
+func (e *winnersExport) abort() {
+	if e.csvFile != nil {
+		e.csvFile.Abort()
+	}
+	if e.jsonFile != nil {
+		e.jsonFile.Abort()
+	}
+}
+
+// ExportWinners Joins the winner documents with the agency file located
+// at betsPath and writes them to the configured CSV and JSON files
+func (c *Client) ExportWinners(betsPath string, documents []string) error {
+	export, err := c.newWinnersExport(betsPath)
+	if err != nil {
+		return err
+	}
+	if err := export.add(documents); err != nil {
+		export.abort()
+		return err
+	}
+	if err := export.commit(); err != nil {
+		return err
+	}
+	c.winnersExported(export.written)
+	return nil
+}
+
+// QueryAndExportWinners Queries the winners of the agency and writes
+// each frame of winners to the configured files as it arrives, joined
+// with the agency file located at betsPath
+func (c *Client) QueryAndExportWinners(betsPath string) error {
+	export, err := c.newWinnersExport(betsPath)
+	if err != nil {
+		log.Errorf("action: exportar_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+
+	var exportErr error
+	var verifier *winnersVerifier
+	err = c.StreamWinners(func(documents []string) error {
+		// The winning number may arrive right before the first winners
+		if verifier == nil && c.config.VerifyWinners {
+			started, err := c.startVerification(betsPath)
+			if err != nil {
+				return err
+			}
+			verifier = started
+			// The export joins the same bets instead of reading them again
+			if verifier.bets != nil && export.bets == nil {
+				export.bets = verifier.bets
+			}
+		}
+		if verifier != nil {
+			verifier.add(documents)
+		}
+		exportErr = export.add(documents)
+		return exportErr
+	})
+	if err == nil {
+		err = export.commit()
+		exportErr = err
+	} else {
+		export.abort()
+	}
+	if exportErr != nil {
+		log.Errorf("action: exportar_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, exportErr)
+	}
+	if err != nil {
+		return err
+	}
+	c.winnersExported(export.written)
+	if verifier != nil && verifier.number >= 0 {
+		return verifier.log(c.config.ID)
+	}
+	return nil
+}
+
+// startVerification Computes the winners of the agency file for the
+// winning number sent by the server or, if it sent none, the configured
+// one. If neither is known the verification is skipped, which is
+// reported with a verifier without number
+func (c *Client) startVerification(betsPath string) (*winnersVerifier, error) {
+	number := c.winningNumber()
+	if configured := c.config.WinningNumber; c.drawKnown && configured >= 0 && configured != number {
+		log.Warningf("action: verificacion_ganadores | result: in_progress | client_id: %v | numero: %v | numero_configurado: %v",
+			c.config.ID,
+			number,
+			configured,
+		)
+	}
+	if number < 0 {
+		log.Warningf("action: verificacion_ganadores | result: skipped | client_id: %v | error: winning number unknown", c.config.ID)
+		return &winnersVerifier{number: -1}, nil
+	}
+	bets, err := c.loadWinningBets(betsPath, number)
+	if err != nil {
+		log.Errorf("action: verificacion_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return nil, err
+	}
+	return newWinnersVerifier(bets, number), nil
+}
+
+// winningNumber Returns the winning number sent by the server or, if it
+// sent none, the configured one. A negative number is unknown
+func (c *Client) winningNumber() int {
+	if c.drawKnown {
+		return c.drawNumber
+	}
+	return c.config.WinningNumber
+}
+
+// winnersExported Logs the export of the winners, if any format is
+// enabled
+func (c *Client) winnersExported(winners int) {
+	if c.config.WinnersCSV == "" && c.config.WinnersJSON == "" {
+		return
+	}
+	log.Infof("action: exportar_ganadores | result: success | client_id: %v | cantidad: %v | csv: %v | json: %v",
+		c.config.ID,
+		winners,
+		c.config.WinnersCSV,
+		c.config.WinnersJSON,
+	)
+}
+
+// winnerRow Returns the CSV row of a winner
+func winnerRow(winner Winner) []string {
+	if !winner.Found {
+		return []string{winner.Document, "", "", "", "", ""}
+	}
+	return []string{
+		winner.Document,
+		winner.FirstName,
+		winner.LastName,
+		winner.Birthdate,
+		strconv.Itoa(*winner.Number),
+		strconv.Itoa(winner.Line),
+	}
+}
+
+// atomicFile File that readers never see with partial content: it is
+// written to a temporary file in the same directory, which is synced and
+// renamed over path on Commit
+type atomicFile struct {
+	*bufio.Writer
+	path string
+	tmp  *os.File
+}
+
+// createAtomicFile Creates the temporary file of path
+func createAtomicFile(path string) (*atomicFile, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create temporary file for %s", path)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, errors.Wrapf(err, "could not set permissions of %s", path)
+	}
+	return &atomicFile{Writer: bufio.NewWriter(tmp), path: path, tmp: tmp}, nil
+}
+
+// Commit Moves the content written so far to path
+func (f *atomicFile) Commit() error {
+	if err := f.stage(); err != nil {
+		f.Abort()
+		return err
+	}
+	return f.rename()
+}
+
+// stage Writes the content to disk, leaving it ready to be renamed
+func (f *atomicFile) stage() error {
+	if err := f.Flush(); err != nil {
+		return errors.Wrapf(err, "could not write %s", f.path)
+	}
+	if err := f.tmp.Sync(); err != nil {
+		return errors.Wrapf(err, "could not sync %s", f.path)
+	}
+	if err := f.tmp.Close(); err != nil {
+		return errors.Wrapf(err, "could not close %s", f.path)
+	}
+	return nil
+}
+
+// rename Moves the staged content to path
+func (f *atomicFile) rename() error {
+	if err := os.Rename(f.tmp.Name(), f.path); err != nil {
+		os.Remove(f.tmp.Name())
+		return errors.Wrapf(err, "could not rename temporary file to %s", f.path)
+	}
+	return nil
+}
+
+// Abort Discards the content written so far
+func (f *atomicFile) Abort() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// writeFileAtomic Writes a file so readers never see partial content
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	file, err := createAtomicFile(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Abort()
+		return errors.Wrapf(err, "could not write %s", path)
+	}
+	return file.Commit()
+}
@@ -0,0 +1,362 @@
+package common
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportWinnersJoinsAgencyFile(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Maria,Perez,11111111,1990-01-01,1234\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	client := NewClient(ClientConfig{
+		ID:            "1",
+		WinnersCSV:    filepath.Join(dir, "winners.csv"),
+		WinnersJSON:   filepath.Join(dir, "winners.json"),
+		WinningNumber: -1,
+	})
+	if err := client.ExportWinners(betsPath, []string{"30904465", "22222222"}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	csv, err := os.ReadFile(client.config.WinnersCSV)
+	if err != nil {
+		t.Fatalf("could not read CSV: %v", err)
+	}
+	// 22222222 is not in the agency file, so only its document is known
+	want := "document,first_name,last_name,birthdate,number,line\n" +
+		"30904465,Santiago Lionel,Lorca,1999-03-17,7574,1\n" +
+		"22222222,,,,,\n"
+	if string(csv) != want {
+		t.Fatalf("got CSV\n%s\nwant\n%s", csv, want)
+	}
+
+	content, err := os.ReadFile(client.config.WinnersJSON)
+	if err != nil {
+		t.Fatalf("could not read JSON: %v", err)
+	}
+	var file winnersFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatalf("could not parse JSON: %v", err)
+	}
+	if file.Agency != "1" || len(file.Winners) != 2 || !file.Winners[0].Found || file.Winners[1].Found {
+		t.Fatalf("unexpected JSON content %+v", file)
+	}
+}
+
+func TestExportWinnersIsDisabledWithoutFiles(t *testing.T) {
+	client := NewClient(ClientConfig{ID: "1"})
+	if err := client.ExportWinners(filepath.Join(t.TempDir(), "missing.csv"), []string{"30904465"}); err != nil {
+		t.Fatalf("export without files should do nothing: %v", err)
+	}
+}
+
+func newWinnersClient(server *testServer, wait time.Duration) *Client {
+	return NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.address()},
+		LoopAmount:      20,
+		LoopPeriod:      10 * time.Millisecond,
+		ResponseTimeout: time.Second,
+		WinnersWait:     wait,
+		WinningNumber:   -1,
+	})
+}
+
+func TestWaitWinnersPushed(t *testing.T) {
+	server := newTestServer(t)
+	server.push = true
+	time.AfterFunc(100*time.Millisecond, server.draw)
+
+	start := time.Now()
+	winners, err := newWinnersClient(server, time.Second).QueryWinners()
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if want := []string{"30904465", "21689196"}; !reflect.DeepEqual(winners, want) {
+		t.Fatalf("got winners %v, want %v", winners, want)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Fatalf("winners pushed after %v, the draw took place after 100ms", elapsed)
+	}
+}
+
+func TestWaitWinnersFallsBackToPolling(t *testing.T) {
+	server := newTestServer(t)
+	time.AfterFunc(50*time.Millisecond, server.draw)
+
+	winners, err := newWinnersClient(server, time.Second).QueryWinners()
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(winners) != 2 {
+		t.Fatalf("got winners %v, want 2", winners)
+	}
+}
+
+func TestWaitWinnersTimesOut(t *testing.T) {
+	server := newTestServer(t)
+	server.push = true
+	defer server.draw()
+
+	client := newWinnersClient(server, 50*time.Millisecond)
+	client.config.LoopAmount = 2
+	if _, err := client.QueryWinners(); err == nil {
+		t.Fatalf("expected the query to fail without a draw")
+	}
+}
+
+func TestWinnersInParts(t *testing.T) {
+	for _, wait := range []time.Duration{0, time.Second} {
+		server := newTestServer(t)
+		server.push = true
+		server.winnersPart = 2
+		server.winners = "1;2;3;4;5"
+		server.draw()
+
+		winners, err := newWinnersClient(server, wait).QueryWinners()
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		if want := []string{"1", "2", "3", "4", "5"}; !reflect.DeepEqual(winners, want) {
+			t.Fatalf("got winners %v, want %v", winners, want)
+		}
+	}
+}
+
+func TestQueryAndExportWinnersInParts(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,2201\n" +
+		"Agustin Emanuel,Zambrano,21689196,2000-05-10,9325\n" +
+		"Maria,Perez,11111111,1990-01-01,7574\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	server := newTestServer(t)
+	server.winnersPart = 1
+	server.winners = "30904465;99999999;11111111"
+	server.draw()
+	client := newWinnersClient(server, 0)
+	client.config.WinnersCSV = filepath.Join(dir, "winners.csv")
+	client.config.WinnersJSON = filepath.Join(dir, "winners.json")
+
+	if err := client.QueryAndExportWinners(betsPath); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+
+	csv, err := os.ReadFile(client.config.WinnersCSV)
+	if err != nil {
+		t.Fatalf("could not read CSV: %v", err)
+	}
+	want := "document,first_name,last_name,birthdate,number,line\n" +
+		"30904465,Santiago Lionel,Lorca,1999-03-17,2201,1\n" +
+		"99999999,,,,,\n" +
+		"11111111,Maria,Perez,1990-01-01,7574,3\n"
+	if string(csv) != want {
+		t.Fatalf("got CSV\n%s\nwant\n%s", csv, want)
+	}
+
+	content, err := os.ReadFile(client.config.WinnersJSON)
+	if err != nil {
+		t.Fatalf("could not read JSON: %v", err)
+	}
+	var file winnersFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		t.Fatalf("invalid JSON %s: %v", content, err)
+	}
+	if file.Agency != "1" || len(file.Winners) != 3 || file.Winners[1].Found || *file.Winners[2].Number != 7574 {
+		t.Fatalf("unexpected JSON content %+v", file)
+	}
+	// A winner without a bet in the agency file has no number
+	if file.Winners[1].Number != nil || strings.Contains(string(content), `"number":0`) {
+		t.Fatalf("number written for a winner not found: %s", content)
+	}
+}
+
+func TestExportOnlyWinningBets(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,2201\n" +
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Maria,Perez,11111111,1990-01-01,1234\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	server := newTestServer(t)
+	server.drawNumber = "7574"
+	server.winners = "30904465;11111111"
+	server.draw()
+	client := newWinnersClient(server, 0)
+	client.config.WinnersCSV = filepath.Join(dir, "winners.csv")
+	client.config.WinnersJSON = filepath.Join(dir, "winners.json")
+
+	if err := client.QueryAndExportWinners(betsPath); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	csv, err := os.ReadFile(client.config.WinnersCSV)
+	if err != nil {
+		t.Fatalf("could not read CSV: %v", err)
+	}
+	// 11111111 has no bet on the winning number
+	want := "document,first_name,last_name,birthdate,number,line\n" +
+		"30904465,Santiago Lionel,Lorca,1999-03-17,7574,2\n" +
+		"11111111,,,,,\n"
+	if string(csv) != want {
+		t.Fatalf("got CSV\n%s\nwant\n%s", csv, want)
+	}
+}
+
+func TestExportKeepsPreviousFilesIfOneFails(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	if err := os.WriteFile(betsPath, []byte("Maria,Perez,30904465,1990-01-01,7574\n"), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	csvPath := filepath.Join(dir, "winners.csv")
+	if err := os.WriteFile(csvPath, []byte("previous\n"), 0644); err != nil {
+		t.Fatalf("could not write CSV: %v", err)
+	}
+
+	server := newTestServer(t)
+	server.draw()
+	client := newWinnersClient(server, 0)
+	client.config.WinnersCSV = csvPath
+	client.config.WinnersJSON = filepath.Join(dir, "winners.json")
+	export, err := client.newWinnersExport(betsPath)
+	if err != nil {
+		t.Fatalf("could not create export: %v", err)
+	}
+	if err := export.add([]string{"30904465"}); err != nil {
+		t.Fatalf("could not add winners: %v", err)
+	}
+	// Closing the JSON file makes it fail to be staged
+	export.jsonFile.tmp.Close()
+
+	if err := export.commit(); err == nil {
+		t.Fatalf("expected the commit to fail")
+	}
+	if content, _ := os.ReadFile(csvPath); string(content) != "previous\n" {
+		t.Fatalf("CSV replaced although the JSON file failed: %q", content)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp-*")); len(matches) > 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestExportReadsAgencyFileOnce(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Maria,Perez,11111111,1990-01-01,7574\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	client := newWinnersClient(newTestServer(t), 0)
+	client.config.WinningNumber = 7574
+	client.config.WinnersCSV = filepath.Join(dir, "winners.csv")
+	export, err := client.newWinnersExport(betsPath)
+	if err != nil {
+		t.Fatalf("could not create export: %v", err)
+	}
+	defer export.abort()
+
+	if err := export.add([]string{"30904465"}); err != nil {
+		t.Fatalf("could not add first frame: %v", err)
+	}
+	// The next frames are joined without the agency file
+	if err := os.Remove(betsPath); err != nil {
+		t.Fatalf("could not remove agency file: %v", err)
+	}
+	if err := export.add([]string{"11111111"}); err != nil {
+		t.Fatalf("could not add second frame: %v", err)
+	}
+	if export.written != 2 {
+		t.Fatalf("wrote %d winners, want 2", export.written)
+	}
+}
+
+func TestExportKeepsOnlyWinningBets(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Maria,Perez,11111111,1990-01-01,2201\n" +
+		"Juan,Gomez,22222222,1985-05-05,7574\n" +
+		"Ana,Diaz,33333333,1970-07-07,1234\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+
+	known := newWinnersClient(newTestServer(t), 0)
+	known.config.WinningNumber = 7574
+	known.config.WinnersCSV = filepath.Join(dir, "known.csv")
+	export, err := known.newWinnersExport(betsPath)
+	if err != nil {
+		t.Fatalf("could not create export: %v", err)
+	}
+	defer export.abort()
+	if err := export.add([]string{"30904465"}); err != nil {
+		t.Fatalf("could not add frame: %v", err)
+	}
+	if len(export.bets) != 2 || export.bets["22222222"] == nil {
+		t.Fatalf("kept bets of %d documents, want only the 2 on the winning number", len(export.bets))
+	}
+
+	// Without a winning number nothing is kept between frames
+	unknown := newWinnersClient(newTestServer(t), 0)
+	unknown.config.WinnersCSV = filepath.Join(dir, "unknown.csv")
+	export, err = unknown.newWinnersExport(betsPath)
+	if err != nil {
+		t.Fatalf("could not create export: %v", err)
+	}
+	defer export.abort()
+	for _, documents := range [][]string{{"11111111"}, {"33333333"}} {
+		if err := export.add(documents); err != nil {
+			t.Fatalf("could not add frame: %v", err)
+		}
+		if export.bets != nil {
+			t.Fatalf("kept %d documents between frames, want none", len(export.bets))
+		}
+	}
+	if export.written != 2 {
+		t.Fatalf("wrote %d winners, want 2", export.written)
+	}
+}
+
+func TestExportSkipsRejectedBets(t *testing.T) {
+	dir := t.TempDir()
+	betsPath := filepath.Join(dir, "agency-1.csv")
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"Santiago Lionel,Lorca,30904465,1999-03-17,1234\n"
+	if err := os.WriteFile(betsPath, []byte(rows), 0644); err != nil {
+		t.Fatalf("could not write agency file: %v", err)
+	}
+	client := newWinnersClient(newTestServer(t), 0)
+	client.config.WinnersCSV = filepath.Join(dir, "winners.csv")
+	// The server refused the second bet during the upload
+	client.rejectedLines[2] = true
+	export, err := client.newWinnersExport(betsPath)
+	if err != nil {
+		t.Fatalf("could not create export: %v", err)
+	}
+	defer export.abort()
+
+	if err := export.add([]string{"30904465"}); err != nil {
+		t.Fatalf("could not add frame: %v", err)
+	}
+	if export.written != 1 {
+		t.Fatalf("wrote %d winners, want only the bet that was not refused", export.written)
+	}
+}
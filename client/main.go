@@ -33,10 +33,88 @@ func InitConfig() (*viper.Viper, error) {
 
 	// Add env variables supported
 	v.BindEnv("id")
+	v.BindEnv("ids")
+	v.BindEnv("contest", "id")
+	v.BindEnv("contest", "files")
 	v.BindEnv("server", "address")
+	v.BindEnv("server", "policy")
+	v.BindEnv("server", "ejectFor")
+	v.BindEnv("server", "timeout")
+	v.BindEnv("heartbeat", "interval")
+	v.BindEnv("heartbeat", "misses")
+	v.BindEnv("heartbeat", "keepAlive")
 	v.BindEnv("loop", "period")
 	v.BindEnv("loop", "amount")
 	v.BindEnv("log", "level")
+	v.BindEnv("batch", "maxAmount")
+	v.BindEnv("batch", "window")
+	v.BindEnv("batch", "retries")
+	v.BindEnv("bets", "file")
+	v.BindEnv("bets", "charset")
+	v.BindEnv("deadLetter", "file")
+	v.BindEnv("winners", "csv")
+	v.BindEnv("winners", "json")
+	v.BindEnv("winners", "wait")
+	v.BindEnv("digest", "required")
+	v.BindEnv("verification", "enabled")
+	v.BindEnv("verification", "number")
+	v.BindEnv("summary", "file")
+	v.BindEnv("capture", "file")
+	v.BindEnv("validation", "document", "enabled")
+	v.BindEnv("validation", "document", "minDigits")
+	v.BindEnv("validation", "document", "maxDigits")
+	v.BindEnv("validation", "birthdate", "enabled")
+	v.BindEnv("validation", "birthdate", "minYear")
+	v.BindEnv("validation", "age", "enabled")
+	v.BindEnv("validation", "age", "min")
+	v.BindEnv("validation", "number", "enabled")
+	v.BindEnv("validation", "number", "min")
+	v.BindEnv("validation", "number", "max")
+	v.BindEnv("validation", "names", "enabled")
+	v.BindEnv("duplicates", "policy")
+	v.BindEnv("duplicates", "exactMaxSize")
+	v.BindEnv("duplicates", "filterBits")
+	v.BindEnv("rate", "bets")
+	v.BindEnv("rate", "betsBurst")
+	v.BindEnv("rate", "batches")
+	v.BindEnv("rate", "batchesBurst")
+	v.BindEnv("rate", "bytes")
+	v.BindEnv("rate", "bytesBurst")
+	v.BindEnv("debug", "faults", "shortWrites")
+	v.BindEnv("debug", "faults", "shortReads")
+	v.BindEnv("debug", "faults", "latency")
+	v.BindEnv("debug", "faults", "dropAfter")
+	v.BindEnv("debug", "faults", "corruptRate")
+	v.BindEnv("debug", "faults", "stallAfter")
+	v.BindEnv("debug", "faults", "stallFor")
+	v.BindEnv("debug", "faults", "seed")
+
+	// Bets are validated unless the rules are explicitly disabled
+	v.SetDefault("validation.document.enabled", true)
+	v.SetDefault("validation.document.minDigits", 7)
+	v.SetDefault("validation.document.maxDigits", 8)
+	v.SetDefault("validation.birthdate.enabled", true)
+	v.SetDefault("validation.birthdate.minYear", 1900)
+	v.SetDefault("validation.age.enabled", true)
+	v.SetDefault("validation.age.min", 18)
+	v.SetDefault("validation.number.enabled", true)
+	v.SetDefault("validation.number.min", 0)
+	v.SetDefault("validation.number.max", 9999)
+	v.SetDefault("validation.names.enabled", true)
+	v.SetDefault("server.policy", common.PolicyFailover)
+	v.SetDefault("server.ejectFor", "30s")
+	v.SetDefault("server.timeout", "30s")
+	v.SetDefault("winners.wait", "0s")
+	v.SetDefault("heartbeat.interval", "0s")
+	v.SetDefault("heartbeat.misses", 3)
+	v.SetDefault("heartbeat.keepAlive", "15s")
+	v.SetDefault("contest.id", common.DefaultContest)
+	v.SetDefault("digest.required", true)
+	v.SetDefault("verification.enabled", true)
+	v.SetDefault("verification.number", -1)
+	v.SetDefault("duplicates.policy", common.DuplicateWarn)
+	v.SetDefault("duplicates.exactMaxSize", 8<<20)
+	v.SetDefault("duplicates.filterBits", 8<<20)
 
 	// Try to read configuration from config file. If config file
 	// does not exists then ReadInConfig will fail but configuration
@@ -52,6 +130,39 @@ func InitConfig() (*viper.Viper, error) {
 	if _, err := time.ParseDuration(v.GetString("loop.period")); err != nil {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
+	if _, err := time.ParseDuration(v.GetString("server.ejectFor")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_EJECTFOR env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("server.timeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_TIMEOUT env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("winners.wait")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_WINNERS_WAIT env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("heartbeat.interval")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_HEARTBEAT_INTERVAL env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("heartbeat.keepAlive")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_HEARTBEAT_KEEPALIVE env var as time.Duration.")
+	}
+
+	if policy := v.GetString("server.policy"); policy != common.PolicyFailover && policy != common.PolicyRoundRobin {
+		return nil, errors.Errorf("Unknown server policy %q, expected %s or %s", policy, common.PolicyFailover, common.PolicyRoundRobin)
+	}
+	switch policy := v.GetString("duplicates.policy"); policy {
+	case common.DuplicateDrop, common.DuplicateWarn, common.DuplicateFail:
+	default:
+		return nil, errors.Errorf("Unknown duplicates policy %q, expected %s, %s or %s", policy, common.DuplicateDrop, common.DuplicateWarn, common.DuplicateFail)
+	}
+	if _, err := parseAgencyIDs(v); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_IDS env var")
+	}
+	if err := common.ValidateContest(v.GetString("contest.id")); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_CONTEST_ID env var")
+	}
+	if _, err := common.ParseContestFiles(v.GetString("contest.files")); err != nil {
+		return nil, errors.Wrapf(err, "Invalid CLI_CONTEST_FILES env var")
+	}
 
 	return v, nil
 }
@@ -81,35 +192,42 @@ func InitLogger(logLevel string) error {
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s",
-		v.GetString("id"),
+	ids, _ := parseAgencyIDs(v)
+	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | batch_max_amount: %v | batch_window: %v | log_level: %s",
+		strings.Join(ids, ","),
 		v.GetString("server.address"),
 		v.GetInt("loop.amount"),
 		v.GetDuration("loop.period"),
+		v.GetInt("batch.maxAmount"),
+		v.GetInt("batch.window"),
 		v.GetString("log.level"),
 	)
 }
 
 func main() {
+	name, args := defaultCommand, os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
 	v, err := InitConfig()
 	if err != nil {
 		log.Criticalf("%s", err)
+		os.Exit(1)
 	}
 
 	if err := InitLogger(v.GetString("log.level")); err != nil {
 		log.Criticalf("%s", err)
+		os.Exit(1)
 	}
 
 	// Print program config with debugging purposes
 	PrintConfig(v)
 
-	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopAmount:    v.GetInt("loop.amount"),
-		LoopPeriod:    v.GetDuration("loop.period"),
-	}
-
-	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	os.Exit(cmd.run(v, args))
 }